@@ -0,0 +1,247 @@
+package grpcservice
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationHeader is the metadata key carrying the bearer token
+const authorizationHeader = "authorization"
+
+// Principal describes a caller authenticated by a token
+type Principal struct {
+	Subject string
+	Claims  map[string]interface{}
+}
+
+// principalKey is the context key of the authenticated principal
+type principalKey struct{}
+
+// Verifier checks a bearer token and returns the principal it belongs to
+type Verifier interface {
+	Verify(ctx context.Context, token string) (*Principal, error)
+}
+
+// VerifierFunc adapts a function to the Verifier interface
+type VerifierFunc func(ctx context.Context, token string) (*Principal, error)
+
+// Verify calls the function
+func (f VerifierFunc) Verify(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// StaticTokenVerifier accepts a fixed set of tokens, each mapped to the subject it authenticates
+type StaticTokenVerifier map[string]string
+
+// Verify compares the token against all known tokens in constant time
+func (v StaticTokenVerifier) Verify(_ context.Context, token string) (*Principal, error) {
+	var principal *Principal
+	for known, subject := range v {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			principal = &Principal{Subject: subject}
+		}
+	}
+	if principal == nil {
+		return nil, errors.New("Authentication: Unknown token")
+	}
+	return principal, nil
+}
+
+// HMACJWTVerifier verifies JSON web tokens signed with HS256, HS384 or HS512 and a shared secret.
+// Expiry and not-before claims are always checked, issuer and audience only if set.
+// Tokens without expiry are rejected unless AllowMissingExpiry is set.
+type HMACJWTVerifier struct {
+	Secret   []byte
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+	// AllowMissingExpiry accepts tokens without exp claim, they never expire
+	AllowMissingExpiry bool
+}
+
+// Verify checks signature and claims of the token
+func (v *HMACJWTVerifier) Verify(_ context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Authentication: Malformed JWT")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	var newHash func() hash.Hash
+	switch header.Algorithm {
+	case "HS256":
+		newHash = sha256.New
+	case "HS384":
+		newHash = sha512.New384
+	case "HS512":
+		newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("Authentication: Unsupported JWT algorithm %q", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Authentication: Malformed JWT signature")
+	}
+	mac := hmac.New(newHash, v.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("Authentication: Invalid JWT signature")
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	return &Principal{Subject: subject, Claims: claims}, nil
+}
+
+func (v *HMACJWTVerifier) verifyClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+			return errors.New("Authentication: JWT is expired")
+		}
+	} else if !v.AllowMissingExpiry {
+		return errors.New("Authentication: JWT has no expiry")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Before(time.Unix(int64(nbf), 0).Add(-v.Leeway)) {
+			return errors.New("Authentication: JWT is not valid yet")
+		}
+	}
+	if v.Issuer != "" {
+		if issuer, _ := claims["iss"].(string); issuer != v.Issuer {
+			return fmt.Errorf("Authentication: Unexpected JWT issuer %q", issuer)
+		}
+	}
+	if v.Audience != "" && !containsAudience(claims["aud"], v.Audience) {
+		return errors.New("Authentication: JWT is not meant for this audience")
+	}
+	return nil
+}
+
+func containsAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTPart(part string, value interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("Authentication: Malformed JWT encoding")
+	}
+	if err := json.Unmarshal(decoded, value); err != nil {
+		return errors.New("Authentication: Malformed JWT content")
+	}
+	return nil
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal put into the context by the auth interceptors
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// bearerToken extracts the token from the authorization metadata of an incoming call
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return "", errors.New("Authentication: Missing authorization header")
+	}
+	const prefix = "bearer "
+	if len(values[0]) <= len(prefix) || !strings.EqualFold(values[0][:len(prefix)], prefix) {
+		return "", errors.New("Authentication: Authorization header is no bearer token")
+	}
+	return strings.TrimSpace(values[0][len(prefix):]), nil
+}
+
+// authenticate verifies the token of the call unless its method is exempt
+func authenticate(ctx context.Context, fullMethod string, verifier Verifier,
+	logger *server.Logger, exemptMethods []string) (context.Context, error) {
+	for _, exempt := range exemptMethods {
+		if matchesMethod(exempt, fullMethod) {
+			return ctx, nil
+		}
+	}
+	token, err := bearerToken(ctx)
+	if err == nil {
+		var principal *Principal
+		if principal, err = verifier.Verify(ctx, token); err == nil {
+			return ContextWithPrincipal(ctx, principal), nil
+		}
+	}
+	logger.TryWarningf(ctx, "Authentication failed for %s: %v", fullMethod, err)
+	return nil, status.Error(codes.Unauthenticated, err.Error())
+}
+
+// AuthUnaryInterceptor rejects unary calls without a bearer token accepted by the verifier
+// with codes.Unauthenticated and reports the failure to the warning channel of the logger.
+// Failures are not reported while the logger is busy, so floods of calls are never held up.
+// Exempt methods are given as full method names or as "/package.Service/*", e.g. for health checks.
+// The authenticated principal is available from PrincipalFromContext within handlers.
+func AuthUnaryInterceptor(verifier Verifier, logger *server.Logger,
+	exemptMethods ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, info.FullMethod, verifier, logger, exemptMethods)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, request)
+	}
+}
+
+// AuthStreamInterceptor is the streaming counterpart of AuthUnaryInterceptor
+func AuthStreamInterceptor(verifier Verifier, logger *server.Logger,
+	exemptMethods ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), info.FullMethod, verifier, logger, exemptMethods)
+		if err != nil {
+			return err
+		}
+		return handler(srv, withContext(stream, ctx))
+	}
+}
@@ -0,0 +1,220 @@
+package grpcservice_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var secured = &grpctest.FakeService{
+	Name: "test.Secured",
+	Unary: map[string]grpctest.UnaryHandler{
+		"Whoami": func(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+			subject := ""
+			if principal, ok := grpcservice.PrincipalFromContext(ctx); ok {
+				subject = principal.Subject
+			}
+			return structpb.NewStruct(map[string]interface{}{"subject": subject})
+		},
+		"Health": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+			return request, nil
+		},
+	},
+}
+
+// signJWT creates a HS256 signed token with the given claims
+func signJWT(secret string, claims map[string]interface{}) string {
+	encode := func(value interface{}) string {
+		encoded, _ := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(encoded)
+	}
+	unsigned := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestSuiteAuth(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("StaticTokenVerifier", func(t *testing.T) {
+		verifier := grpcservice.StaticTokenVerifier{"secret-token": "service-a"}
+
+		principal, err := verifier.Verify(ctx, "secret-token")
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, principal.Subject, "service-a")
+
+		_, err = verifier.Verify(ctx, "other-token")
+		test.AssertThat(t, err, "Authentication: Unknown token", "streq")
+	})
+
+	t.Run("HMACJWTVerifier", func(t *testing.T) {
+		verifier := &grpcservice.HMACJWTVerifier{Secret: []byte("secret"),
+			Issuer: "issuer", Audience: "audience"}
+		valid := map[string]interface{}{"sub": "user", "iss": "issuer",
+			"aud": []string{"other", "audience"}, "exp": time.Now().Add(time.Hour).Unix()}
+
+		t.Run("AcceptsValidToken", func(t *testing.T) {
+			principal, err := verifier.Verify(ctx, signJWT("secret", valid))
+			test.AssertThat(t, err, nil)
+			test.AssertThat(t, principal.Subject, "user")
+			test.AssertThat(t, principal.Claims["iss"], "issuer")
+		})
+
+		t.Run("RejectsWrongSecret", func(t *testing.T) {
+			_, err := verifier.Verify(ctx, signJWT("wrong", valid))
+			test.AssertThat(t, err, "Authentication: Invalid JWT signature", "streq")
+		})
+
+		t.Run("RejectsExpiredToken", func(t *testing.T) {
+			_, err := verifier.Verify(ctx, signJWT("secret", map[string]interface{}{
+				"iss": "issuer", "aud": "audience", "exp": time.Now().Add(-time.Hour).Unix()}))
+			test.AssertThat(t, err, "Authentication: JWT is expired", "streq")
+		})
+
+		t.Run("RejectsTokenWithoutExpiryUnlessAllowed", func(t *testing.T) {
+			claims := map[string]interface{}{"sub": "user", "iss": "issuer", "aud": "audience"}
+			_, err := verifier.Verify(ctx, signJWT("secret", claims))
+			test.AssertThat(t, err, "Authentication: JWT has no expiry", "streq")
+
+			lenient := *verifier
+			lenient.AllowMissingExpiry = true
+			principal, err := lenient.Verify(ctx, signJWT("secret", claims))
+			test.AssertThat(t, err, nil)
+			test.AssertThat(t, principal.Subject, "user")
+		})
+
+		t.Run("RejectsWrongIssuerAndAudience", func(t *testing.T) {
+			exp := time.Now().Add(time.Hour).Unix()
+			_, err := verifier.Verify(ctx, signJWT("secret", map[string]interface{}{
+				"iss": "other", "aud": "audience", "exp": exp}))
+			test.AssertThat(t, err, "Authentication: Unexpected JWT issuer", "contains")
+
+			_, err = verifier.Verify(ctx, signJWT("secret", map[string]interface{}{
+				"iss": "issuer", "aud": "other", "exp": exp}))
+			test.AssertThat(t, err, "Authentication: JWT is not meant for this audience", "streq")
+		})
+
+		t.Run("RejectsUnsignedToken", func(t *testing.T) {
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+			claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`))
+			_, err := verifier.Verify(ctx, header+"."+claims+".")
+			test.AssertThat(t, err, "Authentication: Unsupported JWT algorithm", "contains")
+
+			_, err = verifier.Verify(ctx, "not-a-jwt")
+			test.AssertThat(t, err, "Authentication: Malformed JWT", "streq")
+		})
+	})
+
+	t.Run("Interceptor", func(t *testing.T) {
+		logger := &server.Logger{WarningChan: make(chan string, 10)}
+		verifier := grpcservice.VerifierFunc(func(ctx context.Context, token string) (*grpcservice.Principal, error) {
+			if token != "valid" {
+				return nil, errors.New("rejected")
+			}
+			return &grpcservice.Principal{Subject: "caller"}, nil
+		})
+		harness := grpctest.New(t, secured.Register, grpcservice.WithUnaryInterceptors(
+			grpcservice.AuthUnaryInterceptor(verifier, logger, "/test.Secured/Health")))
+
+		t.Run("ValidTokenPassesPrincipal", func(t *testing.T) {
+			response, err := harness.Invoke(withToken("valid"), "test.Secured", "Whoami", nil)
+			test.AssertThat(t, err, nil)
+			test.AssertThat(t, response.Fields["subject"].GetStringValue(), "caller")
+		})
+
+		t.Run("InvalidTokenIsUnauthenticated", func(t *testing.T) {
+			_, err := harness.Invoke(metadata.AppendToOutgoingContext(withToken("invalid"),
+				"x-request-id", "auth-1"), "test.Secured", "Whoami", nil)
+			test.AssertThat(t, status.Code(err), codes.Unauthenticated)
+			test.AssertThat(t, <-logger.WarningChan,
+				"request_id=auth-1 Authentication failed for /test.Secured/Whoami: rejected", "streq")
+		})
+
+		t.Run("MissingTokenIsUnauthenticated", func(t *testing.T) {
+			_, err := harness.Invoke(ctx, "test.Secured", "Whoami", nil)
+			test.AssertThat(t, status.Code(err), codes.Unauthenticated)
+			test.AssertThat(t, <-logger.WarningChan, "Missing authorization header", "contains")
+		})
+
+		t.Run("BusyLoggerDoesNotHoldUpRejections", func(t *testing.T) {
+			busy := &server.Logger{WarningChan: make(chan string)}
+			busyHarness := grpctest.New(t, secured.Register, grpcservice.WithUnaryInterceptors(
+				grpcservice.AuthUnaryInterceptor(verifier, busy)))
+			callCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			_, err := busyHarness.Invoke(callCtx, "test.Secured", "Whoami", nil)
+			test.AssertThat(t, status.Code(err), codes.Unauthenticated)
+		})
+
+		t.Run("ExemptMethodNeedsNoToken", func(t *testing.T) {
+			_, err := harness.Invoke(ctx, "test.Secured", "Health", nil)
+			test.AssertThat(t, err, nil)
+		})
+	})
+
+	t.Run("GRPCWebServerValidatesAuthorizationHeader", func(t *testing.T) {
+		// SetUp
+		webServer := grpcservice.NewGRPCWebServer(false, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"),
+			grpcservice.WithUnaryInterceptors(grpcservice.AuthUnaryInterceptor(
+				grpcservice.StaticTokenVerifier{"web-token": "web"}, nil)))
+		secured.Register(webServer.GetInnerInstance())
+		go webServer.Serve()
+
+		request, _ := proto.Marshal(&structpb.Struct{})
+		frame := make([]byte, 5, 5+len(request))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+		frame = append(frame, request...)
+		call := func(authorization string) string {
+			req, _ := http.NewRequest(http.MethodPost,
+				"http://"+webServer.Addr().String()+"/test.Secured/Whoami", bytes.NewReader(frame))
+			req.Header.Set("Content-Type", "application/grpc-web+proto")
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			var response *http.Response
+			var err error
+			for i := 0; i < 100; i++ {
+				if response, err = http.DefaultClient.Do(req); err == nil {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			test.AssertThat(t, err, nil)
+			defer response.Body.Close()
+			body := new(bytes.Buffer)
+			body.ReadFrom(response.Body)
+			if grpcStatus := response.Header.Get("Grpc-Status"); grpcStatus != "" {
+				return grpcStatus
+			}
+			return body.String()
+		}
+
+		// Exercise + Verify
+		test.AssertThat(t, call(""), "16")
+		test.AssertThat(t, call("Bearer web-token"), "grpc-status: 0", "contains")
+	})
+}
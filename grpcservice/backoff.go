@@ -0,0 +1,77 @@
+package grpcservice
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Defaults of the backoff policy used when ConnectionInfo.Backoff is not set
+const (
+	defaultBackoffInitial    = time.Second
+	defaultBackoffMax        = 30 * time.Second
+	defaultBackoffMultiplier = 1.6
+	defaultBackoffJitter     = 0.2
+)
+
+// BackoffPolicy describes how long to wait between attempts
+type BackoffPolicy struct {
+	// Initial is the delay before the first retry, it defaults to one second
+	Initial time.Duration
+	// Max caps the delay, zero leaves it uncapped
+	Max time.Duration
+	// Multiplier grows the delay after every retry, values below 1 keep it constant
+	Multiplier float64
+	// Jitter randomizes each delay by up to the given fraction in both directions,
+	// e.g. 0.2 waits between 80% and 120% of the delay, so clients started together
+	// do not retry in lockstep
+	Jitter float64
+	// Deadline limits the total time spent on all attempts, zero means no limit
+	Deadline time.Duration
+}
+
+// defaultBackoffPolicy returns the policy used when the connection info has none.
+// It starts with RetryAfterMilliSecs, if given.
+func defaultBackoffPolicy(info *ConnectionInfo) BackoffPolicy {
+	policy := BackoffPolicy{Initial: defaultBackoffInitial, Max: defaultBackoffMax,
+		Multiplier: defaultBackoffMultiplier, Jitter: defaultBackoffJitter}
+	if info.RetryAfterMilliSecs > 0 {
+		policy.Initial = time.Duration(info.RetryAfterMilliSecs) * time.Millisecond
+	}
+	return policy
+}
+
+// Delay returns the jittered delay before the given retry, counting from 0
+func (p BackoffPolicy) Delay(retry int) time.Duration {
+	delay := float64(p.Initial)
+	if delay <= 0 {
+		delay = float64(defaultBackoffInitial)
+	}
+	if p.Multiplier > 1 {
+		delay *= math.Pow(p.Multiplier, float64(retry))
+	}
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+	if jitter := math.Min(math.Max(p.Jitter, 0), 1); jitter > 0 {
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	// an uncapped delay overflows after enough retries
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// sleep waits for the delay unless the context is done first
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
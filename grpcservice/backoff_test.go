@@ -0,0 +1,56 @@
+package grpcservice_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+func TestSuiteBackoffPolicy(t *testing.T) {
+	t.Run("DelayGrowsUpToMax", func(t *testing.T) {
+		// SetUp
+		policy := grpcservice.BackoffPolicy{Initial: 100 * time.Millisecond,
+			Max: time.Second, Multiplier: 3}
+
+		// Exercise + Verify
+		test.AssertThat(t, policy.Delay(0), 100*time.Millisecond)
+		test.AssertThat(t, policy.Delay(1), 300*time.Millisecond)
+		test.AssertThat(t, policy.Delay(2), 900*time.Millisecond)
+		test.AssertThat(t, policy.Delay(3), time.Second)
+	})
+
+	t.Run("UncappedDelayDoesNotOverflow", func(t *testing.T) {
+		// SetUp
+		policy := grpcservice.BackoffPolicy{Initial: time.Second, Multiplier: 2}
+
+		// Exercise + Verify
+		test.AssertThat(t, policy.Delay(100), time.Duration(math.MaxInt64))
+		test.AssertThat(t, policy.Delay(2000), time.Duration(math.MaxInt64))
+	})
+
+	t.Run("DelayIsConstantWithoutMultiplier", func(t *testing.T) {
+		// SetUp
+		policy := grpcservice.BackoffPolicy{Initial: 50 * time.Millisecond}
+
+		// Exercise + Verify
+		test.AssertThat(t, policy.Delay(5), 50*time.Millisecond)
+		test.AssertThat(t, grpcservice.BackoffPolicy{}.Delay(0), time.Second)
+	})
+
+	t.Run("JitterStaysWithinBounds", func(t *testing.T) {
+		// SetUp
+		policy := grpcservice.BackoffPolicy{Initial: time.Second, Jitter: 0.5}
+		distinct := make(map[time.Duration]bool)
+
+		// Exercise + Verify
+		for i := 0; i < 100; i++ {
+			delay := policy.Delay(0)
+			distinct[delay] = true
+			test.AssertThat(t, delay >= 500*time.Millisecond && delay <= 1500*time.Millisecond, true)
+		}
+		test.AssertThat(t, len(distinct) > 1, true)
+	})
+}
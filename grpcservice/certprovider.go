@@ -0,0 +1,193 @@
+package grpcservice
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/server"
+)
+
+// CertificateProvider serves a TLS certificate and client CA pool loaded from files.
+// The files are polled for changes and reloaded without restarting the server,
+// so renewed certificates are picked up by new connections.
+type CertificateProvider struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *server.Logger
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	fileStates  map[string]fileState
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// errMissingClientCAs is returned when mutual TLS is requested from a provider without CA file
+var errMissingClientCAs = errors.New("Certificate provider: mutual TLS requires a CA file")
+
+// fileState is used to detect changes of a watched file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertificateProvider loads the certificate, key and optional CA file and
+// polls them for changes every interval. An interval <= 0 disables polling,
+// Reload can still be called manually then. Reload results are reported
+// through the optional logger without waiting for it.
+func NewCertificateProvider(certFile, keyFile, caFile string, interval time.Duration,
+	logger *server.Logger) (*CertificateProvider, error) {
+	provider := &CertificateProvider{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   logger,
+		stopChan: make(chan struct{}),
+	}
+	if err := provider.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go provider.watch(interval)
+	}
+	return provider, nil
+}
+
+// hasClientCAs indicates whether the provider has a CA file to verify clients with
+func (p *CertificateProvider) hasClientCAs() bool {
+	return p.caFile != ""
+}
+
+// Reload loads the files again. On failure the previous certificate stays in use.
+func (p *CertificateProvider) Reload() error {
+	states := p.currentFileStates()
+
+	certificate, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		err = fmt.Errorf("Certificate provider: load cert/key error: %v", err)
+		p.logger.TryErrorf(context.Background(), "%v", err)
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if p.caFile != "" {
+		caCert, err := ioutil.ReadFile(p.caFile)
+		if err != nil {
+			err = fmt.Errorf("Certificate provider: read ca cert file error: %v", err)
+			p.logger.TryErrorf(context.Background(), "%v", err)
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCert) {
+			err = fmt.Errorf("Certificate provider: no certificates found in %s", p.caFile)
+			p.logger.TryErrorf(context.Background(), "%v", err)
+			return err
+		}
+	}
+
+	p.mutex.Lock()
+	p.certificate = &certificate
+	p.clientCAs = clientCAs
+	p.fileStates = states
+	p.mutex.Unlock()
+
+	p.logger.TryInfof(context.Background(), "Certificate provider: Loaded certificate from %s", p.certFile)
+	return nil
+}
+
+// Stop ends polling the files for changes
+func (p *CertificateProvider) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
+
+// GetCertificate returns the current certificate, it is meant for tls.Config.GetCertificate
+func (p *CertificateProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.certificate, nil
+}
+
+// GetConfigForClient returns a mutual TLS configuration with the current
+// certificate and client CA pool, it is meant for tls.Config.GetConfigForClient.
+// It fails without a CA file, clients would be verified against the system roots otherwise.
+func (p *CertificateProvider) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.clientCAs == nil {
+		return nil, errMissingClientCAs
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*p.certificate},
+		ClientCAs:    p.clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// TLSConfig returns a server configuration served by the provider,
+// mutual requires clients to present a certificate signed by the CA file and
+// fails all handshakes of a provider without CA file
+func (p *CertificateProvider) TLSConfig(mutual bool) *tls.Config {
+	if mutual {
+		return &tls.Config{GetConfigForClient: p.GetConfigForClient}
+	}
+	return &tls.Config{GetCertificate: p.GetCertificate}
+}
+
+func (p *CertificateProvider) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			if p.changed() {
+				p.Reload()
+			}
+		}
+	}
+}
+
+// changed indicates whether any of the files was modified since the last reload attempt
+func (p *CertificateProvider) changed() bool {
+	states := p.currentFileStates()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	changed := false
+	for file, state := range states {
+		if p.fileStates[file] != state {
+			changed = true
+		}
+	}
+	// remember the state to only retry a failed reload on the next modification
+	p.fileStates = states
+	return changed
+}
+
+func (p *CertificateProvider) currentFileStates() map[string]fileState {
+	states := make(map[string]fileState)
+	for _, file := range []string{p.certFile, p.keyFile, p.caFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			states[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+		} else {
+			states[file] = fileState{}
+		}
+	}
+	return states
+}
@@ -0,0 +1,202 @@
+package grpcservice_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+// writeCert issues a certificate for commonName and writes it over the given files
+func writeCert(t *testing.T, ca *grpctest.CA, certFile, keyFile, commonName string) {
+	keyPair := ca.Issue(t, grpctest.CertificateRequest{CommonName: commonName,
+		Hosts: []string{"localhost"}, Server: true, Client: true})
+	test.AssertThat(t, keyPair.WriteFiles(certFile, keyFile), nil)
+
+	// make sure the modification is visible even on coarse file system timestamps
+	future := time.Now().Add(time.Duration(len(commonName)) * time.Second)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+}
+
+func servedCommonName(t *testing.T, provider *grpcservice.CertificateProvider) string {
+	certificate, err := provider.GetCertificate(nil)
+	test.AssertThat(t, err, nil)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	test.AssertThat(t, err, nil)
+	return leaf.Subject.CommonName
+}
+
+func TestSuiteCertificateProvider(t *testing.T) {
+	ca := grpctest.NewCA(t, "Test CA")
+
+	t.Run("CreateFailsOnMissingFiles", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := grpcservice.NewCertificateProvider(
+			filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), "", 0, nil)
+		test.AssertThat(t, err, "Certificate provider: load cert/key error", "contains")
+	})
+
+	t.Run("CreateFailsOnInvalidCA", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+
+		_, err := grpcservice.NewCertificateProvider(certFile, keyFile, keyFile, 0, nil)
+		test.AssertThat(t, err, "Certificate provider: no certificates found in", "contains")
+	})
+
+	t.Run("ChangedFilesAreReloaded", func(t *testing.T) {
+		// SetUp
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+		provider, err := grpcservice.NewCertificateProvider(
+			certFile, keyFile, ca.File(t), 10*time.Millisecond, nil)
+		test.AssertThat(t, err, nil)
+		defer provider.Stop()
+		test.AssertThat(t, servedCommonName(t, provider), "first")
+
+		// Exercise
+		writeCert(t, ca, certFile, keyFile, "second")
+
+		// Verify
+		deadline := time.Now().Add(2 * time.Second)
+		for servedCommonName(t, provider) != "second" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		test.AssertThat(t, servedCommonName(t, provider), "second")
+	})
+
+	t.Run("FailedReloadKeepsCertificateAndReportsError", func(t *testing.T) {
+		// SetUp
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+		logger := server.NewLogger(t.Name(), "", "", nil, nil, nil, nil, nil, server.Debug)
+		provider, err := grpcservice.NewCertificateProvider(certFile, keyFile, "", 0, logger)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, <-logger.LogChan, "Certificate provider: Loaded certificate", "contains")
+
+		// Exercise
+		test.AssertThat(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600), nil)
+		err = provider.Reload()
+
+		// Verify
+		test.AssertThat(t, err, nil, "not")
+		test.AssertThat(t, <-logger.ErrorChan, err)
+		test.AssertThat(t, servedCommonName(t, provider), "first")
+	})
+
+	t.Run("BusyLoggerDoesNotHoldUpReloads", func(t *testing.T) {
+		// SetUp
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+		logger := &server.Logger{LogChan: make(chan string), ErrorChan: make(chan error)}
+		provider, err := grpcservice.NewCertificateProvider(certFile, keyFile, "", 0, logger)
+		test.AssertThat(t, err, nil)
+		close(logger.ErrorChan)
+
+		// Exercise
+		test.AssertThat(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600), nil)
+		err = provider.Reload()
+
+		// Verify
+		test.AssertThat(t, err, "Certificate provider: load cert/key error", "contains")
+	})
+
+	t.Run("ServerServesReloadedCertificate", func(t *testing.T) {
+		// SetUp
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+		provider, err := grpcservice.NewCertificateProvider(certFile, keyFile, "", 0, nil)
+		test.AssertThat(t, err, nil)
+		tempServer := grpcservice.NewGRPCServer(true, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"), grpcservice.WithCertificateProvider(provider))
+		startServer(t, tempServer)
+
+		handshake := func() string {
+			conn, err := tls.Dial("tcp", tempServer.Addr().String(),
+				&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+			test.AssertThat(t, err, nil)
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		}
+		test.AssertThat(t, handshake(), "first")
+
+		// Exercise
+		writeCert(t, ca, certFile, keyFile, "second")
+		test.AssertThat(t, provider.Reload(), nil)
+
+		// Verify
+		test.AssertThat(t, handshake(), "second")
+
+		// TearDown
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+	t.Run("MutualServerVerifiesClientsWithReloadableCA", func(t *testing.T) {
+		// SetUp
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+		provider, err := grpcservice.NewCertificateProvider(certFile, keyFile, ca.File(t), 0, nil)
+		test.AssertThat(t, err, nil)
+		tempServer := grpcservice.NewMutualGRPCServer(true, "", "", "", 0,
+			grpcservice.WithHost("127.0.0.1"), grpcservice.WithCertificateProvider(provider))
+		startServer(t, tempServer)
+
+		handshake := func(certificates []tls.Certificate) error {
+			conn, err := tls.Dial("tcp", tempServer.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true, NextProtos: []string{"h2"}, Certificates: certificates})
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			// client certificate failures are reported with the first read in TLS 1.3
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, err = conn.Read(make([]byte, 1))
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		clientCert := ca.ClientCert(t, "client").TLSCertificate(t)
+
+		// Exercise + Verify
+		test.AssertThat(t, handshake(nil), "certificate required", "contains")
+		test.AssertThat(t, handshake([]tls.Certificate{clientCert}), nil)
+
+		// TearDown
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+	t.Run("MutualServerRequiresCA", func(t *testing.T) {
+		// SetUp
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		writeCert(t, ca, certFile, keyFile, "first")
+		provider, err := grpcservice.NewCertificateProvider(certFile, keyFile, "", 0, nil)
+		test.AssertThat(t, err, nil)
+
+		// Exercise
+		tempServer := grpcservice.NewMutualGRPCServer(true, "", "", "", 0,
+			grpcservice.WithHost("127.0.0.1"), grpcservice.WithCertificateProvider(provider))
+		_, configErr := provider.GetConfigForClient(nil)
+
+		// Verify
+		test.AssertThat(t, tempServer == nil, true)
+		test.AssertThat(t, configErr, "Certificate provider: mutual TLS requires a CA file", "contains")
+	})
+}
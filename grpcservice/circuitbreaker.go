@@ -0,0 +1,277 @@
+package grpcservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults of CircuitBreaker
+const (
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitCoolDown         = 30 * time.Second
+	defaultCircuitHalfOpenCalls    = 1
+)
+
+// defaultCircuitFailureCodes count as failures unless CircuitBreaker.FailureCodes is set
+var defaultCircuitFailureCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded,
+	codes.ResourceExhausted, codes.Internal, codes.Unknown}
+
+// CircuitState is the state of a circuit
+type CircuitState int
+
+// States of a circuit
+const (
+	// CircuitClosed lets all calls pass
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all calls fast until the cool-down passed
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial calls pass to decide whether to close again
+	CircuitHalfOpen
+)
+
+// circuitStates are all states exported by the circuit state gauge
+var circuitStates = []CircuitState{CircuitClosed, CircuitOpen, CircuitHalfOpen}
+
+// String returns the name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops calling a failing downstream service. A circuit opens after
+// FailureThreshold consecutive failures and fails all calls fast with codes.Unavailable.
+// After the cool-down it lets HalfOpenCalls trial calls pass, which close the circuit
+// again if they all succeed or reopen it on the first failure.
+// Its fields must not be changed once it is in use.
+type CircuitBreaker struct {
+	// PerMethod keeps one circuit per full method name instead of one per target
+	PerMethod bool
+	// FailureThreshold is the number of consecutive failures opening a circuit, 5 by default
+	FailureThreshold int
+	// CoolDown is the time a circuit stays open before trial calls are let through, 30s by default
+	CoolDown time.Duration
+	// HalfOpenCalls is the number of successful trial calls closing a circuit again, 1 by default
+	HalfOpenCalls int
+	// FailureCodes are the status codes counting as failures, Unavailable, DeadlineExceeded,
+	// ResourceExhausted, Internal and Unknown by default. Calls cancelled by the caller never count.
+	FailureCodes []codes.Code
+	// Logger receives state changes, opening circuits are logged as warnings
+	Logger *server.Logger
+	// Metrics exports the circuit states and the calls rejected by open circuits
+	Metrics *Metrics
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of a single circuit. The generation changes with every
+// state change, so results of calls started in an earlier state are ignored.
+type circuit struct {
+	state      CircuitState
+	generation int
+	failures   int
+	openedAt   time.Time
+	trials     int
+	successes  int
+}
+
+// State returns the state of the circuit of a target or full method name
+func (b *CircuitBreaker) State(key string) CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if c, ok := b.circuits[key]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+func (b *CircuitBreaker) key(cc *grpc.ClientConn, method string) string {
+	if b.PerMethod {
+		return method
+	}
+	return cc.Target()
+}
+
+func (b *CircuitBreaker) failureThreshold() int {
+	if b.FailureThreshold > 0 {
+		return b.FailureThreshold
+	}
+	return defaultCircuitFailureThreshold
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown > 0 {
+		return b.CoolDown
+	}
+	return defaultCircuitCoolDown
+}
+
+func (b *CircuitBreaker) halfOpenCalls() int {
+	if b.HalfOpenCalls > 0 {
+		return b.HalfOpenCalls
+	}
+	return defaultCircuitHalfOpenCalls
+}
+
+// failure reports whether the error of a call counts as failure
+func (b *CircuitBreaker) failure(err error) bool {
+	failureCodes := b.FailureCodes
+	if len(failureCodes) == 0 {
+		failureCodes = defaultCircuitFailureCodes
+	}
+	code := status.Code(err)
+	for _, failureCode := range failureCodes {
+		if code == failureCode {
+			return true
+		}
+	}
+	return false
+}
+
+// allow admits a call or rejects it with codes.Unavailable.
+// An admitted call has to report its result to the returned function.
+func (b *CircuitBreaker) allow(ctx context.Context, key string) (func(error), error) {
+	var logTransition func()
+	defer func() {
+		if logTransition != nil {
+			logTransition()
+		}
+	}()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.coolDown() {
+		logTransition = b.transition(ctx, key, c, CircuitHalfOpen)
+	}
+	if c.state == CircuitOpen || (c.state == CircuitHalfOpen && c.trials >= b.halfOpenCalls()) {
+		if b.Metrics != nil {
+			b.Metrics.rejectCircuitCall(key)
+		}
+		return nil, status.Errorf(codes.Unavailable, "GRPC client: Circuit of %s is %v", key, c.state)
+	}
+	if c.state == CircuitHalfOpen {
+		c.trials++
+	}
+
+	generation := c.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			b.record(ctx, key, c, generation, err)
+		})
+	}, nil
+}
+
+// record updates the circuit with the result of a call
+func (b *CircuitBreaker) record(ctx context.Context, key string, c *circuit, generation int, err error) {
+	var logTransition func()
+	defer func() {
+		if logTransition != nil {
+			logTransition()
+		}
+	}()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if c.generation != generation {
+		return
+	}
+	failed := err != nil && b.failure(err)
+	ignored := err != nil && !failed && status.Code(err) == codes.Canceled
+
+	switch c.state {
+	case CircuitClosed:
+		if failed {
+			c.failures++
+			if c.failures >= b.failureThreshold() {
+				logTransition = b.transition(ctx, key, c, CircuitOpen)
+			}
+		} else if !ignored {
+			c.failures = 0
+		}
+	case CircuitHalfOpen:
+		c.trials--
+		if failed {
+			logTransition = b.transition(ctx, key, c, CircuitOpen)
+		} else if !ignored {
+			c.successes++
+			if c.successes >= b.halfOpenCalls() {
+				logTransition = b.transition(ctx, key, c, CircuitClosed)
+			}
+		}
+	}
+}
+
+// transition changes the state of a circuit, it has to be called with the mutex held.
+// The returned function logs the change without waiting for the logger and has to be
+// called after releasing the mutex.
+func (b *CircuitBreaker) transition(ctx context.Context, key string, c *circuit, state CircuitState) func() {
+	from := c.state
+	*c = circuit{state: state, generation: c.generation + 1}
+	if b.Metrics != nil {
+		b.Metrics.SetCircuitState(key, state)
+	}
+	if state == CircuitOpen {
+		c.openedAt = time.Now()
+		return func() {
+			b.Logger.TryWarningf(ctx, "GRPC client: Circuit of %s is %v after %v", key, state, from)
+		}
+	}
+	return func() {
+		b.Logger.TryInfof(ctx, "GRPC client: Circuit of %s is %v after %v", key, state, from)
+	}
+}
+
+// UnaryClientInterceptor fails unary calls fast while their circuit is open
+func (b *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, request, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := b.allow(ctx, b.key(cc, method))
+		if err != nil {
+			return err
+		}
+		err = invoker(ctx, method, request, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor fails streaming calls fast while their circuit is open.
+// A stream counts once it ended or its context is done, so cancelled trial streams
+// free their slot in a half-open circuit.
+func (b *CircuitBreaker) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := b.allow(ctx, b.key(cc, method))
+		if err != nil {
+			return nil, err
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+		return (&finishingClientStream{ClientStream: stream, serverStreams: desc.ServerStreams,
+			finish: done}).watch(ctx), nil
+	}
+}
@@ -0,0 +1,179 @@
+package grpcservice_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSuiteCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	getMethod := "/test.Flaky/Get"
+
+	connect := func(t *testing.T, calls *int32, failures int32, code codes.Code,
+		breaker *grpcservice.CircuitBreaker) *grpctest.Harness {
+		harness := grpctest.New(t, newFlaky(calls, failures, code).Register)
+		harness.Client = harness.Connect(&grpcservice.ConnectionInfo{CircuitBreaker: breaker})
+		return harness
+	}
+
+	t.Run("OpensAfterThresholdAndFailsFast", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		logger := &server.Logger{LogChan: make(chan string, 10), WarningChan: make(chan string, 10)}
+		metrics := grpcservice.NewMetrics()
+		breaker := &grpcservice.CircuitBreaker{PerMethod: true, FailureThreshold: 3,
+			Logger: logger, Metrics: metrics}
+		harness := connect(t, &calls, 100, codes.Unavailable, breaker)
+		for i := 0; i < 3; i++ {
+			_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+			test.AssertThat(t, err, "Flaky", "contains")
+		}
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, status.Code(err), codes.Unavailable)
+		test.AssertThat(t, err, "GRPC client: Circuit of /test.Flaky/Get is open", "contains")
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(3))
+		test.AssertThat(t, breaker.State(getMethod), grpcservice.CircuitOpen)
+		test.AssertThat(t, <-logger.WarningChan, "Circuit of /test.Flaky/Get is open after closed", "contains")
+		scraped := scrape(t, metrics)
+		test.AssertThat(t, scraped,
+			`grpc_client_circuit_state{circuit="/test.Flaky/Get",state="open"} 1`, "contains")
+		test.AssertThat(t, scraped,
+			`grpc_client_circuit_state{circuit="/test.Flaky/Get",state="closed"} 0`, "contains")
+		test.AssertThat(t, scraped,
+			`grpc_client_circuit_rejected_total{circuit="/test.Flaky/Get"} 1`, "contains")
+	})
+
+	t.Run("BusyLoggerDoesNotHoldUpCalls", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		logger := &server.Logger{LogChan: make(chan string), WarningChan: make(chan string)}
+		breaker := &grpcservice.CircuitBreaker{FailureThreshold: 1, Logger: logger}
+		harness := connect(t, &calls, 100, codes.Unavailable, breaker)
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		// Exercise
+		_, err := harness.Invoke(callCtx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, err, "Flaky", "contains")
+		test.AssertThat(t, callCtx.Err(), nil)
+	})
+
+	t.Run("SuccessfulTrialCloses", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		breaker := &grpcservice.CircuitBreaker{PerMethod: true, FailureThreshold: 2,
+			CoolDown: 20 * time.Millisecond}
+		harness := connect(t, &calls, 2, codes.Unavailable, breaker)
+		harness.Invoke(ctx, "test.Flaky", "Get", nil)
+		harness.Invoke(ctx, "test.Flaky", "Get", nil)
+		test.AssertThat(t, breaker.State(getMethod), grpcservice.CircuitOpen)
+
+		// Exercise
+		time.Sleep(30 * time.Millisecond)
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, breaker.State(getMethod), grpcservice.CircuitClosed)
+	})
+
+	t.Run("FailingTrialReopens", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		breaker := &grpcservice.CircuitBreaker{PerMethod: true, FailureThreshold: 2,
+			CoolDown: 20 * time.Millisecond}
+		harness := connect(t, &calls, 3, codes.Unavailable, breaker)
+		harness.Invoke(ctx, "test.Flaky", "Get", nil)
+		harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Exercise
+		time.Sleep(30 * time.Millisecond)
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, err, "Flaky", "contains")
+		test.AssertThat(t, breaker.State(getMethod), grpcservice.CircuitOpen)
+		_, err = harness.Invoke(ctx, "test.Flaky", "Get", nil)
+		test.AssertThat(t, err, "is open", "contains")
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(3))
+	})
+
+	t.Run("CancelledTrialStreamFreesItsSlot", func(t *testing.T) {
+		// SetUp
+		breaker := &grpcservice.CircuitBreaker{PerMethod: true, FailureThreshold: 1,
+			CoolDown: 20 * time.Millisecond, FailureCodes: []codes.Code{codes.NotFound}}
+		harness := grpctest.New(t, newMeasured().Register)
+		harness.Client = harness.Connect(&grpcservice.ConnectionInfo{CircuitBreaker: breaker})
+		harness.Invoke(ctx, "test.Measured", "Fail", nil)
+		test.AssertThat(t, breaker.State("/test.Measured/Fail"), grpcservice.CircuitOpen)
+		time.Sleep(30 * time.Millisecond)
+		streamCtx, cancel := context.WithCancel(ctx)
+		_, err := harness.NewStream(streamCtx, "test.Measured", "Fail")
+		_, rejected := harness.Invoke(ctx, "test.Measured", "Fail", nil)
+		test.AssertThat(t, rejected, "is half-open", "contains")
+
+		// Exercise
+		cancel()
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		eventually(t, func() bool {
+			_, err := harness.Invoke(ctx, "test.Measured", "Fail", nil)
+			return status.Code(err) == codes.NotFound
+		})
+	})
+
+	t.Run("CircuitIsSharedPerTarget", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		breaker := &grpcservice.CircuitBreaker{FailureThreshold: 2}
+		harness := connect(t, &calls, 100, codes.Unavailable, breaker)
+		harness.Invoke(ctx, "test.Flaky", "Get", nil)
+		harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Update", nil)
+
+		// Verify
+		test.AssertThat(t, err, "is open", "contains")
+		test.AssertThat(t, breaker.State(harness.Connection().Target()), grpcservice.CircuitOpen)
+		test.AssertThat(t, breaker.State(getMethod), grpcservice.CircuitClosed)
+	})
+
+	t.Run("OtherCodesDoNotCount", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		breaker := &grpcservice.CircuitBreaker{PerMethod: true, FailureThreshold: 2}
+		harness := connect(t, &calls, 5, codes.NotFound, breaker)
+
+		// Exercise
+		for i := 0; i < 5; i++ {
+			harness.Invoke(ctx, "test.Flaky", "Get", nil)
+		}
+
+		// Verify
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(5))
+		test.AssertThat(t, breaker.State(getMethod), grpcservice.CircuitClosed)
+	})
+
+	t.Run("StateNames", func(t *testing.T) {
+		// Exercise + Verify
+		test.AssertThat(t, grpcservice.CircuitHalfOpen.String(), "half-open")
+		test.AssertThat(t, grpcservice.CircuitState(7).String(), "unknown")
+	})
+}
@@ -0,0 +1,84 @@
+package grpcservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// supportedTLSVersions are the TLS versions usable with HTTP/2 by name
+var supportedTLSVersions = map[uint16]string{
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// clientTLSConfig returns the TLS configuration of the connection info. Without mutual
+// authentication CertFile holds the certificates to trust, the system pool is used if
+// it is empty. With mutual authentication CertFile and KeyFile are the client certificate
+// and CaFile holds the certificates to trust.
+func clientTLSConfig(info *ConnectionInfo, mutual bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         info.ServerHostName,
+		MinVersion:         tls.VersionTLS12,
+		NextProtos:         info.NextProtos,
+		InsecureSkipVerify: info.InsecureSkipVerify,
+	}
+	if info.MinTLSVersion != 0 {
+		if _, ok := supportedTLSVersions[info.MinTLSVersion]; !ok {
+			return nil, fmt.Errorf("GRPC client: Unsupported minimum TLS version 0x%04x", info.MinTLSVersion)
+		}
+		config.MinVersion = info.MinTLSVersion
+	}
+	if len(info.CipherSuites) > 0 {
+		if err := checkCipherSuites(info.CipherSuites); err != nil {
+			return nil, err
+		}
+		config.CipherSuites = info.CipherSuites
+	}
+
+	caFile := info.CertFile
+	if mutual {
+		peerCert, err := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
+		if err != nil {
+			log.Printf("GRPC client: load peer cert/key error: %v", err)
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{peerCert}
+		caFile = info.CaFile
+	}
+	if caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			log.Printf("GRPC client: read ca cert file error: %v", err)
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("GRPC client: No certificates found in %s", caFile)
+		}
+	}
+
+	if info.InsecureSkipVerify {
+		log.Printf("GRPC client: WARNING! TLS certificate verification of %s is DISABLED. "+
+			"Anyone can intercept this connection, never use InsecureSkipVerify in production!",
+			info.target())
+	}
+	return config, nil
+}
+
+// checkCipherSuites rejects unknown and insecure cipher suites
+func checkCipherSuites(suites []uint16) error {
+	secure := make(map[uint16]bool)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.ID] = true
+	}
+	for _, suite := range suites {
+		if !secure[suite] {
+			return fmt.Errorf("GRPC client: Unsupported or insecure cipher suite %s",
+				tls.CipherSuiteName(suite))
+		}
+	}
+	return nil
+}
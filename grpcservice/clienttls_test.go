@@ -0,0 +1,202 @@
+package grpcservice_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+// helloRecorder records the client hellos of a TLS listener
+type helloRecorder struct {
+	mutex  sync.Mutex
+	hellos []*tls.ClientHelloInfo
+}
+
+func (r *helloRecorder) last() *tls.ClientHelloInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.hellos) == 0 {
+		return nil
+	}
+	return r.hellos[len(r.hellos)-1]
+}
+
+// startTLSServer starts a server terminating TLS with the key pair in its listener
+func startTLSServer(t *testing.T, keyPair *grpctest.KeyPair, maxVersion uint16) (string, *helloRecorder) {
+	recorder := &helloRecorder{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	test.AssertThat(t, err, nil)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{keyPair.TLSCertificate(t)},
+		NextProtos:   []string{"h2"},
+		MaxVersion:   maxVersion,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			recorder.mutex.Lock()
+			defer recorder.mutex.Unlock()
+			recorder.hellos = append(recorder.hellos, hello)
+			return nil, nil
+		},
+	}
+	tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+		grpcservice.WithListener(tls.NewListener(listener, config)))
+	startServer(t, tempServer)
+	t.Cleanup(func() {
+		tempServer.Stop()
+	})
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port, recorder
+}
+
+// connectTLS connects with TLS and closes the client after the test
+func connectTLS(t *testing.T, info *grpcservice.ConnectionInfo) error {
+	info.UseTLS, info.IP = true, "127.0.0.1"
+	if info.TimeoutInMilliSecs == 0 {
+		info.TimeoutInMilliSecs = 1000
+	}
+	tempClient := new(grpcservice.GRPCClient)
+	err := tempClient.Connect(info)
+	if err == nil {
+		t.Cleanup(func() {
+			tempClient.Close()
+		})
+	}
+	return err
+}
+
+func TestSuiteClientTLS(t *testing.T) {
+	ca := grpctest.NewCA(t, "Test CA")
+	caFile := ca.File(t)
+	backend := ca.ServerCert(t, "backend.internal")
+
+	t.Run("ServerHostNameIsSentAndVerified", func(t *testing.T) {
+		// SetUp
+		port, hellos := startTLSServer(t, backend, 0)
+
+		// Exercise
+		err := connectTLS(t, &grpcservice.ConnectionInfo{CertFile: caFile, Port: port,
+			ServerHostName: "backend.internal", NextProtos: []string{"custom"}})
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, hellos.last().ServerName, "backend.internal")
+		test.AssertThat(t, strings.Join(hellos.last().SupportedProtos, ","), "custom,h2")
+	})
+
+	t.Run("ConnectingFailsWithoutMatchingServerHostName", func(t *testing.T) {
+		// SetUp
+		port, _ := startTLSServer(t, backend, 0)
+
+		// Exercise
+		err := connectTLS(t, &grpcservice.ConnectionInfo{CertFile: caFile, Port: port,
+			ServerHostName: "other.internal", TimeoutInMilliSecs: 200})
+
+		// Verify
+		var connectErr *grpcservice.ConnectError
+		test.AssertThat(t, errors.As(err, &connectErr), true)
+		test.AssertThat(t, connectErr.Kind.String(), grpcservice.ConnectErrorTLS.String())
+	})
+
+	t.Run("ConnectingFailsBelowMinTLSVersion", func(t *testing.T) {
+		// SetUp
+		port, _ := startTLSServer(t, backend, tls.VersionTLS12)
+
+		// Exercise
+		err := connectTLS(t, &grpcservice.ConnectionInfo{CertFile: caFile, Port: port,
+			ServerHostName: "backend.internal", MinTLSVersion: tls.VersionTLS13,
+			TimeoutInMilliSecs: 200})
+
+		// Verify
+		var connectErr *grpcservice.ConnectError
+		test.AssertThat(t, errors.As(err, &connectErr), true)
+		test.AssertThat(t, connectErr.Kind.String(), grpcservice.ConnectErrorTLS.String())
+	})
+
+	t.Run("CipherSuitesAreApplied", func(t *testing.T) {
+		// SetUp
+		port, hellos := startTLSServer(t, backend, tls.VersionTLS12)
+
+		// Exercise
+		err := connectTLS(t, &grpcservice.ConnectionInfo{CertFile: caFile, Port: port,
+			ServerHostName: "backend.internal",
+			CipherSuites:   []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}})
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		offered := make(map[uint16]bool)
+		for _, suite := range hellos.last().CipherSuites {
+			offered[suite] = true
+		}
+		test.AssertThat(t, offered[tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256], true)
+		test.AssertThat(t, offered[tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256], false)
+	})
+
+	t.Run("InvalidSettingsFail", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			info     grpcservice.ConnectionInfo
+			expected string
+		}{
+			"MinTLSVersion": {grpcservice.ConnectionInfo{MinTLSVersion: tls.VersionTLS10},
+				"GRPC client: Unsupported minimum TLS version 0x0301"},
+			"InsecureCipherSuite": {grpcservice.ConnectionInfo{
+				CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}},
+				"GRPC client: Unsupported or insecure cipher suite TLS_RSA_WITH_RC4_128_SHA"},
+			"UnknownCipherSuite": {grpcservice.ConnectionInfo{CipherSuites: []uint16{0x1234}},
+				"GRPC client: Unsupported or insecure cipher suite 0x1234"},
+			"EmptyCertFile": {grpcservice.ConnectionInfo{CertFile: os.DevNull},
+				"GRPC client: No certificates found in " + os.DevNull},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				// Exercise
+				err := connectTLS(t, &tc.info)
+
+				// Verify
+				var connectErr *grpcservice.ConnectError
+				test.AssertThat(t, errors.As(err, &connectErr), true)
+				test.AssertThat(t, connectErr.Kind.String(), grpcservice.ConnectErrorTLS.String())
+				test.AssertThat(t, err, tc.expected, "streq")
+			})
+		}
+	})
+
+	t.Run("InsecureSkipVerifyConnectsAndWarns", func(t *testing.T) {
+		// SetUp
+		port, _ := startTLSServer(t, grpctest.NewCA(t, "Unknown CA").ServerCert(t, "127.0.0.1"), 0)
+		var output bytes.Buffer
+		log.SetOutput(&output)
+		defer log.SetOutput(os.Stderr)
+
+		// Exercise
+		err := connectTLS(t, &grpcservice.ConnectionInfo{Port: port, InsecureSkipVerify: true})
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, output.String(), "TLS certificate verification of passthrough:///127.0.0.1:"+
+			port+" is DISABLED", "contains")
+	})
+
+	t.Run("MutualIsSelectableInConnect", func(t *testing.T) {
+		// SetUp
+		serverCertFile, serverKeyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+		tempServer := grpcservice.NewMutualGRPCServer(true,
+			serverCertFile, serverKeyFile, caFile, 0, grpcservice.WithHost("127.0.0.1"))
+		startServer(t, tempServer)
+		defer tempServer.Stop()
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+		clientCertFile, clientKeyFile := ca.ClientCert(t, "client").Files(t)
+
+		// Exercise + Verify
+		test.AssertThat(t, connectTLS(t, &grpcservice.ConnectionInfo{Mutual: true,
+			CertFile: clientCertFile, KeyFile: clientKeyFile, CaFile: caFile, Port: port}), nil)
+	})
+}
@@ -0,0 +1,520 @@
+package grpcservice
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSource is the source of settings not set by any file or variable
+const defaultSource = "default"
+
+// ClientSettings is the configurable part of ConnectionInfo, see ConnectionInfo for the meaning
+// of the fields. The config tags are the keys in files, environment variables use them in
+// upper snake case behind the prefix, e.g. timeoutInMilliSecs is read from PREFIX_TIMEOUT_IN_MILLI_SECS.
+type ClientSettings struct {
+	IP                     string   `config:"ip"`
+	Port                   string   `config:"port"`
+	Target                 string   `config:"target"`
+	Endpoints              []string `config:"endpoints"`
+	SocketPath             string   `config:"socketPath"`
+	LoadBalancing          string   `config:"loadBalancing"`
+	HealthCheck            bool     `config:"healthCheck"`
+	HealthCheckServiceName string   `config:"healthCheckServiceName"`
+
+	UseTLS             bool   `config:"useTLS"`
+	Mutual             bool   `config:"mutual"`
+	CertFile           string `config:"certFile"`
+	KeyFile            string `config:"keyFile"`
+	CaFile             string `config:"caFile"`
+	ServerHostName     string `config:"serverHostName"`
+	MinTLSVersion      string `config:"minTLSVersion"`
+	InsecureSkipVerify bool   `config:"insecureSkipVerify"`
+
+	TimeoutInMilliSecs           int  `config:"timeoutInMilliSecs"`
+	RetryTimes                   int  `config:"retryTimes"`
+	RetryAfterMilliSecs          int  `config:"retryAfterMilliSecs"`
+	KeepaliveTimeInMilliSecs     int  `config:"keepaliveTimeInMilliSecs"`
+	KeepaliveTimeoutInMilliSecs  int  `config:"keepaliveTimeoutInMilliSecs"`
+	KeepalivePermitWithoutStream bool `config:"keepalivePermitWithoutStream"`
+}
+
+// ServerSettings are the settings of GRPCServer. With CaFile set, clients have to
+// authenticate with a certificate issued by one of its certificate authorities,
+// which requires UseTLS. The timeouts are those of ServerKeepalive, zero keeps the grpc default.
+type ServerSettings struct {
+	Host    string `config:"host"`
+	Port    int    `config:"port"`
+	Address string `config:"address"`
+
+	UseTLS   bool   `config:"useTLS"`
+	CertFile string `config:"certFile"`
+	KeyFile  string `config:"keyFile"`
+	CaFile   string `config:"caFile"`
+
+	KeepaliveTimeInMilliSecs         int `config:"keepaliveTimeInMilliSecs"`
+	KeepaliveTimeoutInMilliSecs      int `config:"keepaliveTimeoutInMilliSecs"`
+	MaxConnectionIdleInMilliSecs     int `config:"maxConnectionIdleInMilliSecs"`
+	MaxConnectionAgeInMilliSecs      int `config:"maxConnectionAgeInMilliSecs"`
+	MaxConnectionAgeGraceInMilliSecs int `config:"maxConnectionAgeGraceInMilliSecs"`
+}
+
+// ConfigSources tells where each setting came from, keyed by its config tag.
+// Sources are "default", "file <path>" or "env <variable>".
+type ConfigSources map[string]string
+
+// String lists the sources sorted by key, e.g. for logging the effective configuration
+func (s ConfigSources) String() string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s=%s", key, s[key])
+	}
+	return b.String()
+}
+
+// ConfigLoader builds settings from config files and environment variables.
+// Files are read in order and variables are read last, later sources override earlier ones.
+type ConfigLoader struct {
+	// Files are JSON, YAML or TOML files, the format is chosen by the extension
+	// .json, .yaml, .yml or .toml. Unknown keys are rejected.
+	Files []string
+	// EnvPrefix selects the environment variables, e.g. "ORDERS_GRPC_" reads ORDERS_GRPC_PORT.
+	// Without a prefix no variables are read.
+	EnvPrefix string
+}
+
+// LoadClient loads and validates client settings
+func (l *ConfigLoader) LoadClient() (*ClientSettings, ConfigSources, error) {
+	settings := &ClientSettings{}
+	sources, err := l.load(settings)
+	if err != nil {
+		return nil, sources, err
+	}
+	if err := settings.validate(sources); err != nil {
+		return nil, sources, err
+	}
+	return settings, sources, nil
+}
+
+// LoadServer loads and validates server settings
+func (l *ConfigLoader) LoadServer() (*ServerSettings, ConfigSources, error) {
+	settings := &ServerSettings{}
+	sources, err := l.load(settings)
+	if err != nil {
+		return nil, sources, err
+	}
+	if err := settings.validate(sources); err != nil {
+		return nil, sources, err
+	}
+	return settings, sources, nil
+}
+
+// load fills the settings pointed to from all sources
+func (l *ConfigLoader) load(settings interface{}) (ConfigSources, error) {
+	fields := configFields(settings)
+	sources := make(ConfigSources, len(fields))
+	for key := range fields {
+		sources[key] = defaultSource
+	}
+
+	for _, file := range l.Files {
+		values, err := readConfigFile(file)
+		if err != nil {
+			return sources, err
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := fields[key]
+			if !ok {
+				return sources, fmt.Errorf("GRPC config: Unknown key %q in %s", key, file)
+			}
+			source := "file " + file
+			if err := setConfigValue(field, values[key]); err != nil {
+				return sources, fmt.Errorf("GRPC config: %s from %s %v", key, source, err)
+			}
+			sources[key] = source
+		}
+	}
+
+	if l.EnvPrefix != "" {
+		for key, field := range fields {
+			variable := l.EnvPrefix + envName(key)
+			value, ok := os.LookupEnv(variable)
+			if !ok {
+				continue
+			}
+			source := "env " + variable
+			if err := setConfigValue(field, value); err != nil {
+				return sources, fmt.Errorf("GRPC config: %s from %s %v", key, source, err)
+			}
+			sources[key] = source
+		}
+	}
+	return sources, nil
+}
+
+// configFields returns the settable fields of the settings struct by their config tag
+func configFields(settings interface{}) map[string]reflect.Value {
+	value := reflect.ValueOf(settings).Elem()
+	fields := make(map[string]reflect.Value, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		if key := value.Type().Field(i).Tag.Get("config"); key != "" {
+			fields[key] = value.Field(i)
+		}
+	}
+	return fields
+}
+
+// readConfigFile decodes a config file into its top level keys
+func readConfigFile(file string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("GRPC config: %v", err)
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		return nil, fmt.Errorf("GRPC config: Unknown format of %s, expected .json, .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GRPC config: Parsing %s failed: %v", file, err)
+	}
+	return values, nil
+}
+
+// envName converts a config key to upper snake case, e.g. minTLSVersion to MIN_TLS_VERSION
+func envName(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) ||
+				(unicode.IsUpper(previous) && nextIsLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// setConfigValue assigns a decoded value or the text of a variable to a field
+func setConfigValue(field reflect.Value, value interface{}) error {
+	switch field.Kind() {
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			field.SetString(v)
+		case int, int64, uint64, json.Number:
+			field.SetString(fmt.Sprint(v))
+		case float64:
+			if v != math.Trunc(v) {
+				return fmt.Errorf("is %v, expected a string", v)
+			}
+			field.SetString(strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return fmt.Errorf("is %v, expected a string", value)
+		}
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			field.SetBool(v)
+		case string:
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("is %q, expected true or false", v)
+			}
+			field.SetBool(parsed)
+		default:
+			return fmt.Errorf("is %v, expected true or false", value)
+		}
+	case reflect.Int:
+		var parsed int64
+		var err error
+		switch v := value.(type) {
+		case int:
+			parsed = int64(v)
+		case int64:
+			parsed = v
+		case uint64:
+			if v > math.MaxInt64 {
+				err = errors.New("out of range")
+			}
+			parsed = int64(v)
+		case float64:
+			if v != math.Trunc(v) {
+				err = errors.New("fractional")
+			}
+			parsed = int64(v)
+		case json.Number:
+			parsed, err = v.Int64()
+		case string:
+			parsed, err = strconv.ParseInt(strings.TrimSpace(v), 10, 0)
+		default:
+			err = errors.New("wrong type")
+		}
+		if err != nil || parsed != int64(int(parsed)) {
+			return fmt.Errorf("is %v, expected an integer", value)
+		}
+		field.SetInt(parsed)
+	case reflect.Slice:
+		var items []string
+		switch v := value.(type) {
+		case string:
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				text, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("contains %v, expected a list of strings", item)
+				}
+				items = append(items, text)
+			}
+		default:
+			return fmt.Errorf("is %v, expected a list of strings", value)
+		}
+		field.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// settingError describes an invalid setting along with its source
+func settingError(sources ConfigSources, key string, format string, args ...interface{}) error {
+	return fmt.Errorf("GRPC config: %s from %s %s", key, sources[key], fmt.Sprintf(format, args...))
+}
+
+// checkFile reports a file setting which is not a readable file
+func checkFile(sources ConfigSources, key, file string) error {
+	if file == "" {
+		return settingError(sources, key, "is required with TLS")
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return settingError(sources, key, "is not readable: %v", err)
+	}
+	if info.IsDir() {
+		return settingError(sources, key, "is %s, a directory", file)
+	}
+	return nil
+}
+
+// checkPort reports a port setting not between min and 65535
+func checkPort(sources ConfigSources, key string, port, min int) error {
+	if port < min || port > 65535 {
+		return settingError(sources, key, "is %d, expected %d to 65535", port, min)
+	}
+	return nil
+}
+
+// minTLSVersions are the accepted values of ClientSettings.MinTLSVersion
+var minTLSVersions = map[string]uint16{"": 0, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// validate reports all invalid settings at once
+func (s *ClientSettings) validate(sources ConfigSources) error {
+	var errs []error
+	addresses := 0
+	for _, set := range []bool{s.IP != "" || s.Port != "", s.Target != "",
+		len(s.Endpoints) > 0, s.SocketPath != ""} {
+		if set {
+			addresses++
+		}
+	}
+	switch {
+	case addresses == 0:
+		errs = append(errs, errors.New("GRPC config: One of ip and port, target, endpoints or socketPath is required"))
+	case addresses > 1:
+		errs = append(errs, errors.New("GRPC config: Only one of ip and port, target, endpoints or socketPath may be set"))
+	}
+	if s.IP != "" || s.Port != "" {
+		if s.IP == "" {
+			errs = append(errs, settingError(sources, "ip", "is required with a port"))
+		}
+		port, err := strconv.Atoi(s.Port)
+		if err != nil {
+			errs = append(errs, settingError(sources, "port", "is %q, expected a number", s.Port))
+		} else if err := checkPort(sources, "port", port, 1); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, key := range []string{"timeoutInMilliSecs", "retryTimes", "retryAfterMilliSecs"} {
+		if value := configFields(s)[key].Int(); value < 0 {
+			errs = append(errs, settingError(sources, key, "is %d, expected a positive number", value))
+		}
+	}
+	if _, ok := minTLSVersions[s.MinTLSVersion]; !ok {
+		errs = append(errs, settingError(sources, "minTLSVersion", "is %q, expected 1.2 or 1.3", s.MinTLSVersion))
+	}
+	if s.UseTLS {
+		if s.Mutual {
+			for key, file := range map[string]string{"certFile": s.CertFile, "keyFile": s.KeyFile,
+				"caFile": s.CaFile} {
+				if err := checkFile(sources, key, file); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		} else if s.CertFile != "" {
+			if err := checkFile(sources, "certFile", s.CertFile); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	info := s.ConnectionInfo()
+	if _, err := keepaliveOption(info); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := serviceConfigOption(info); err != nil {
+		errs = append(errs, err)
+	}
+	sortErrors(errs)
+	return errors.Join(errs...)
+}
+
+// validate reports all invalid settings at once
+func (s *ServerSettings) validate(sources ConfigSources) error {
+	var errs []error
+	if err := checkPort(sources, "port", s.Port, 0); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Address != "" && (s.Host != "" || s.Port != 0) {
+		errs = append(errs, errors.New("GRPC config: Only one of address or host and port may be set"))
+	}
+	if s.UseTLS {
+		files := map[string]string{"certFile": s.CertFile, "keyFile": s.KeyFile}
+		if s.CaFile != "" {
+			files["caFile"] = s.CaFile
+		}
+		for key, file := range files {
+			if err := checkFile(sources, key, file); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else if s.CaFile != "" {
+		errs = append(errs, settingError(sources, "caFile",
+			"requires useTLS, clients cannot authenticate without TLS"))
+	}
+	negative := false
+	for _, key := range []string{"keepaliveTimeInMilliSecs", "keepaliveTimeoutInMilliSecs",
+		"maxConnectionIdleInMilliSecs", "maxConnectionAgeInMilliSecs", "maxConnectionAgeGraceInMilliSecs"} {
+		if value := configFields(s)[key].Int(); value < 0 {
+			errs = append(errs, settingError(sources, key, "is %d, expected a positive number", value))
+			negative = true
+		}
+	}
+	if keepalive := s.keepalive(); keepalive != nil && !negative {
+		if err := keepalive.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sortErrors(errs)
+	return errors.Join(errs...)
+}
+
+// sortErrors orders errors by message so they are reported the same way every time
+func sortErrors(errs []error) {
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+}
+
+// ConnectionInfo returns the connection info of the settings. Fields not covered
+// by the settings, like interceptors, can be added before connecting.
+func (s *ClientSettings) ConnectionInfo() *ConnectionInfo {
+	return &ConnectionInfo{
+		UseTLS:                       s.UseTLS,
+		CertFile:                     s.CertFile,
+		ServerHostName:               s.ServerHostName,
+		IP:                           s.IP,
+		Port:                         s.Port,
+		TimeoutInMilliSecs:           s.TimeoutInMilliSecs,
+		RetryTimes:                   s.RetryTimes,
+		RetryAfterMilliSecs:          s.RetryAfterMilliSecs,
+		KeyFile:                      s.KeyFile,
+		CaFile:                       s.CaFile,
+		Mutual:                       s.Mutual,
+		MinTLSVersion:                minTLSVersions[s.MinTLSVersion],
+		InsecureSkipVerify:           s.InsecureSkipVerify,
+		KeepaliveTimeInMilliSecs:     s.KeepaliveTimeInMilliSecs,
+		KeepaliveTimeoutInMilliSecs:  s.KeepaliveTimeoutInMilliSecs,
+		KeepalivePermitWithoutStream: s.KeepalivePermitWithoutStream,
+		Endpoints:                    s.Endpoints,
+		Target:                       s.Target,
+		LoadBalancing:                s.LoadBalancing,
+		HealthCheck:                  s.HealthCheck,
+		HealthCheckServiceName:       s.HealthCheckServiceName,
+		SocketPath:                   s.SocketPath,
+	}
+}
+
+// keepalive returns the keepalive settings or nil if none are set
+func (s *ServerSettings) keepalive() *ServerKeepalive {
+	keepalive := ServerKeepalive{
+		Time:                  time.Duration(s.KeepaliveTimeInMilliSecs) * time.Millisecond,
+		Timeout:               time.Duration(s.KeepaliveTimeoutInMilliSecs) * time.Millisecond,
+		MaxConnectionIdle:     time.Duration(s.MaxConnectionIdleInMilliSecs) * time.Millisecond,
+		MaxConnectionAge:      time.Duration(s.MaxConnectionAgeInMilliSecs) * time.Millisecond,
+		MaxConnectionAgeGrace: time.Duration(s.MaxConnectionAgeGraceInMilliSecs) * time.Millisecond,
+	}
+	if keepalive == (ServerKeepalive{}) {
+		return nil
+	}
+	return &keepalive
+}
+
+// NewServer creates the server described by the settings, a mutual TLS server if CaFile is set.
+// Options given are applied after those of the settings and override them.
+func (s *ServerSettings) NewServer(options ...ServerOption) *GRPCServer {
+	if keepalive := s.keepalive(); keepalive != nil {
+		options = append([]ServerOption{WithKeepalive(*keepalive)}, options...)
+	}
+	if s.Host != "" {
+		options = append([]ServerOption{WithHost(s.Host)}, options...)
+	}
+	if s.Address != "" {
+		options = append([]ServerOption{WithAddress(s.Address)}, options...)
+	}
+	if s.CaFile != "" {
+		return NewMutualGRPCServer(s.UseTLS, s.CertFile, s.KeyFile, s.CaFile, s.Port, options...)
+	}
+	return NewGRPCServer(s.UseTLS, s.CertFile, s.KeyFile, s.Port, options...)
+}
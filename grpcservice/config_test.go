@@ -0,0 +1,247 @@
+package grpcservice_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+// writeConfig writes a config file into a temporary directory and returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	test.AssertThat(t, ioutil.WriteFile(path, []byte(content), 0600), nil)
+	return path
+}
+
+func TestSuiteConfigLoader(t *testing.T) {
+	t.Run("ClientIsLoadedFromEveryFormat", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"client.json": `{"ip": "127.0.0.1", "port": 50051, "timeoutInMilliSecs": 500,
+				"healthCheck": true, "endpoints": []}`,
+			"client.yaml": "ip: 127.0.0.1\nport: 50051\ntimeoutInMilliSecs: 500\nhealthCheck: true\n",
+			"client.toml": "ip = \"127.0.0.1\"\nport = 50051\ntimeoutInMilliSecs = 500\nhealthCheck = true\n",
+		} {
+			content := content
+			t.Run(name, func(t *testing.T) {
+				// SetUp
+				file := writeConfig(t, name, content)
+
+				// Exercise
+				settings, sources, err := (&grpcservice.ConfigLoader{Files: []string{file}}).LoadClient()
+
+				// Verify
+				test.AssertThat(t, err, nil)
+				test.AssertThat(t, settings.IP, "127.0.0.1")
+				test.AssertThat(t, settings.Port, "50051")
+				test.AssertThat(t, settings.TimeoutInMilliSecs, 500)
+				test.AssertThat(t, settings.HealthCheck, true)
+				test.AssertThat(t, sources["port"], "file "+file)
+				test.AssertThat(t, sources["retryTimes"], "default")
+			})
+		}
+	})
+
+	t.Run("LaterSourcesOverrideEarlierOnes", func(t *testing.T) {
+		// SetUp
+		base := writeConfig(t, "base.yaml", "ip: 10.0.0.1\nport: \"443\"\nretryTimes: 3\n")
+		local := writeConfig(t, "local.json", `{"ip": "127.0.0.1"}`)
+		t.Setenv("TEST_GRPC_PORT", "8443")
+		t.Setenv("TEST_GRPC_MIN_TLS_VERSION", "1.3")
+		t.Setenv("TEST_GRPC_USE_TLS", "true")
+		t.Setenv("TEST_GRPC_ENDPOINTS_UNUSED", "ignored")
+
+		// Exercise
+		settings, sources, err := (&grpcservice.ConfigLoader{Files: []string{base, local},
+			EnvPrefix: "TEST_GRPC_"}).LoadClient()
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, settings.IP, "127.0.0.1")
+		test.AssertThat(t, settings.Port, "8443")
+		test.AssertThat(t, settings.RetryTimes, 3)
+		test.AssertThat(t, sources["ip"], "file "+local)
+		test.AssertThat(t, sources["port"], "env TEST_GRPC_PORT")
+		test.AssertThat(t, sources["retryTimes"], "file "+base)
+		test.AssertThat(t, sources["minTLSVersion"], "env TEST_GRPC_MIN_TLS_VERSION")
+		test.AssertThat(t, sources.String(), "useTLS=env TEST_GRPC_USE_TLS", "contains")
+		info := settings.ConnectionInfo()
+		test.AssertThat(t, info.UseTLS, true)
+		test.AssertThat(t, int(info.MinTLSVersion), int(tls.VersionTLS13))
+	})
+
+	t.Run("EndpointsAreReadFromLists", func(t *testing.T) {
+		// SetUp
+		file := writeConfig(t, "client.yaml", "endpoints: [a:1, b:2]\n")
+
+		// Exercise
+		settings, _, err := (&grpcservice.ConfigLoader{Files: []string{file}}).LoadClient()
+		test.AssertThat(t, err, nil)
+		t.Setenv("TEST_GRPC_ENDPOINTS", "c:3, d:4")
+		fromEnv, _, envErr := (&grpcservice.ConfigLoader{EnvPrefix: "TEST_GRPC_"}).LoadClient()
+
+		// Verify
+		test.AssertThat(t, len(settings.Endpoints), 2)
+		test.AssertThat(t, settings.Endpoints[1], "b:2")
+		test.AssertThat(t, envErr, nil)
+		test.AssertThat(t, len(fromEnv.Endpoints), 2)
+		test.AssertThat(t, fromEnv.Endpoints[1], "d:4")
+	})
+
+	t.Run("InvalidSourcesFail", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			file     string
+			content  string
+			env      string
+			expected string
+		}{
+			"UnknownKey": {"client.yaml", "ip: a\nprot: 1\n", "",
+				`GRPC config: Unknown key "prot" in `},
+			"UnknownFormat": {"client.ini", "ip=a", "",
+				"GRPC config: Unknown format of "},
+			"Syntax": {"client.json", "{ip: a}", "",
+				"GRPC config: Parsing "},
+			"WrongType": {"client.yaml", "ip: a\nport: 1\nretryTimes: many\n", "",
+				"retryTimes from file "},
+			"WrongEnvType": {"client.yaml", "ip: a\nport: 1\n", "not-a-number",
+				"GRPC config: timeoutInMilliSecs from env TEST_GRPC_TIMEOUT_IN_MILLI_SECS " +
+					"is not-a-number, expected an integer"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				// SetUp
+				file := writeConfig(t, tc.file, tc.content)
+				if tc.env != "" {
+					t.Setenv("TEST_GRPC_TIMEOUT_IN_MILLI_SECS", tc.env)
+				}
+
+				// Exercise
+				_, _, err := (&grpcservice.ConfigLoader{Files: []string{file},
+					EnvPrefix: "TEST_GRPC_"}).LoadClient()
+
+				// Verify
+				test.AssertThat(t, err, tc.expected, "contains")
+			})
+		}
+	})
+
+	t.Run("AllInvalidClientSettingsAreReported", func(t *testing.T) {
+		// SetUp
+		file := writeConfig(t, "client.yaml", "ip: 127.0.0.1\nport: 70000\nuseTLS: true\n"+
+			"mutual: true\ncertFile: /not/existing\nminTLSVersion: \"1.0\"\nretryTimes: -1\n")
+
+		// Exercise
+		_, _, err := (&grpcservice.ConfigLoader{Files: []string{file}}).LoadClient()
+
+		// Verify
+		source := " from file " + file + " "
+		test.AssertThat(t, err, "GRPC config: port"+source+"is 70000, expected 1 to 65535", "contains")
+		test.AssertThat(t, err, "GRPC config: certFile"+source+"is not readable", "contains")
+		test.AssertThat(t, err, "GRPC config: keyFile from default is required with TLS", "contains")
+		test.AssertThat(t, err, "GRPC config: minTLSVersion"+source+`is "1.0", expected 1.2 or 1.3`,
+			"contains")
+		test.AssertThat(t, err, "GRPC config: retryTimes"+source+"is -1, expected a positive number",
+			"contains")
+	})
+
+	t.Run("ClientAddressIsRequired", func(t *testing.T) {
+		// Exercise
+		_, _, err := (&grpcservice.ConfigLoader{}).LoadClient()
+		_, _, both := (&grpcservice.ConfigLoader{Files: []string{writeConfig(t, "client.toml",
+			"target = \"dns:///a:1\"\nsocketPath = \"/tmp/s\"\n")}}).LoadClient()
+
+		// Verify
+		test.AssertThat(t, err,
+			"GRPC config: One of ip and port, target, endpoints or socketPath is required", "streq")
+		test.AssertThat(t, both,
+			"GRPC config: Only one of ip and port, target, endpoints or socketPath may be set", "streq")
+	})
+
+	t.Run("ClientSettingsAreCheckedLikeConnecting", func(t *testing.T) {
+		// SetUp
+		file := writeConfig(t, "client.yaml",
+			"target: dns:///a:1\nkeepaliveTimeInMilliSecs: 1000\nloadBalancing: random\n")
+
+		// Exercise
+		_, _, err := (&grpcservice.ConfigLoader{Files: []string{file}}).LoadClient()
+
+		// Verify
+		test.AssertThat(t, err, "GRPC client: Keepalive time of 1s is below the minimum of 10s", "contains")
+		test.AssertThat(t, err, `GRPC client: Unknown load balancing policy "random"`, "contains")
+	})
+
+	t.Run("ServerIsLoadedAndCreated", func(t *testing.T) {
+		// SetUp
+		file := writeConfig(t, "server.toml", "host = \"127.0.0.1\"\nport = 0\n")
+
+		// Exercise
+		settings, sources, err := (&grpcservice.ConfigLoader{Files: []string{file}}).LoadServer()
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, sources["host"], "file "+file)
+		tempServer := settings.NewServer()
+		test.AssertThat(t, tempServer.Addr().String(), "127.0.0.1:", "contains")
+		startServer(t, tempServer)
+		test.AssertThat(t, tempServer.Stop(), nil)
+	})
+
+	t.Run("InvalidServerSettingsFail", func(t *testing.T) {
+		// SetUp
+		t.Setenv("TEST_GRPC_PORT", "-1")
+		t.Setenv("TEST_GRPC_USE_TLS", "yes")
+
+		// Exercise
+		_, _, wrongBool := (&grpcservice.ConfigLoader{EnvPrefix: "TEST_GRPC_"}).LoadServer()
+		t.Setenv("TEST_GRPC_USE_TLS", "1")
+		_, _, err := (&grpcservice.ConfigLoader{EnvPrefix: "TEST_GRPC_"}).LoadServer()
+
+		// Verify
+		test.AssertThat(t, wrongBool,
+			`GRPC config: useTLS from env TEST_GRPC_USE_TLS is "yes", expected true or false`, "streq")
+		test.AssertThat(t, err, "GRPC config: port from env TEST_GRPC_PORT is -1, expected 0 to 65535",
+			"contains")
+		test.AssertThat(t, err, "GRPC config: certFile from default is required with TLS", "contains")
+	})
+
+	t.Run("ServerCaFileRequiresTLS", func(t *testing.T) {
+		// SetUp
+		file := writeConfig(t, "server.yaml", "port: 0\ncaFile: /etc/ssl/ca.pem\n")
+
+		// Exercise
+		_, _, err := (&grpcservice.ConfigLoader{Files: []string{file}}).LoadServer()
+
+		// Verify
+		test.AssertThat(t, err, "GRPC config: caFile from file "+file+
+			" requires useTLS, clients cannot authenticate without TLS", "streq")
+	})
+
+	t.Run("ServerTimeoutsAreLoadedAndChecked", func(t *testing.T) {
+		// SetUp
+		valid := writeConfig(t, "server.toml", "host = \"127.0.0.1\"\n"+
+			"keepaliveTimeInMilliSecs = 60000\nmaxConnectionAgeInMilliSecs = 300000\n")
+		invalid := writeConfig(t, "server.yaml", "maxConnectionIdleInMilliSecs: -1\n"+
+			"maxConnectionAgeGraceInMilliSecs: 1000\n")
+
+		// Exercise
+		settings, _, err := (&grpcservice.ConfigLoader{Files: []string{valid}}).LoadServer()
+		_, _, invalidErr := (&grpcservice.ConfigLoader{Files: []string{invalid}}).LoadServer()
+		t.Setenv("TEST_GRPC_MAX_CONNECTION_AGE_GRACE_IN_MILLI_SECS", "1000")
+		_, _, graceErr := (&grpcservice.ConfigLoader{EnvPrefix: "TEST_GRPC_"}).LoadServer()
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, settings.MaxConnectionAgeInMilliSecs, 300000)
+		tempServer := settings.NewServer()
+		test.AssertThat(t, tempServer, nil, "not")
+		startServer(t, tempServer)
+		test.AssertThat(t, tempServer.Stop(), nil)
+		test.AssertThat(t, invalidErr, "GRPC config: maxConnectionIdleInMilliSecs from file "+invalid+
+			" is -1, expected a positive number", "streq")
+		test.AssertThat(t, graceErr,
+			"GRPC server: Keepalive connection age grace requires a maximum connection age", "streq")
+	})
+}
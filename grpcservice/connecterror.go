@@ -0,0 +1,211 @@
+package grpcservice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// ConnectErrorKind tells why connecting to a grpc server failed
+type ConnectErrorKind int
+
+// Kinds of connection failures
+const (
+	// ConnectErrorUnknown is any failure not covered by the other kinds
+	ConnectErrorUnknown ConnectErrorKind = iota
+	// ConnectErrorTLS covers loading certificates as well as failed TLS handshakes
+	ConnectErrorTLS
+	// ConnectErrorDNS means the host name could not be resolved
+	ConnectErrorDNS
+	// ConnectErrorTimeout means no connection was established in time
+	ConnectErrorTimeout
+	// ConnectErrorRefused means nothing listens at the address
+	ConnectErrorRefused
+	// ConnectErrorCancelled means the caller cancelled connecting
+	ConnectErrorCancelled
+)
+
+var connectErrorKindNames = map[ConnectErrorKind]string{
+	ConnectErrorUnknown:   "unknown",
+	ConnectErrorTLS:       "tls",
+	ConnectErrorDNS:       "dns",
+	ConnectErrorTimeout:   "timeout",
+	ConnectErrorRefused:   "refused",
+	ConnectErrorCancelled: "cancelled",
+}
+
+// String returns the name of the kind
+func (k ConnectErrorKind) String() string {
+	return connectErrorKindNames[k]
+}
+
+// ConnectError is returned by the Connect methods of GRPCClient,
+// use errors.As to tell the kinds of failures apart
+type ConnectError struct {
+	Kind ConnectErrorKind
+	// Err is the cause of the failure
+	Err error
+
+	message string
+}
+
+// Error returns the message of the failure
+func (e *ConnectError) Error() string {
+	if e.message != "" {
+		return e.message
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the cause of the failure
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// tlsHandshakeError marks errors of the TLS handshake
+type tlsHandshakeError struct {
+	err error
+}
+
+func (e *tlsHandshakeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tlsHandshakeError) Unwrap() error {
+	return e.err
+}
+
+// attemptError is an attempt ended by err, mostly a timeout, after the cause occurred
+type attemptError struct {
+	err   error
+	cause error
+}
+
+func (e *attemptError) Error() string {
+	if e.err == nil {
+		return e.cause.Error()
+	}
+	return e.err.Error() + ": " + e.cause.Error()
+}
+
+// Unwrap returns the cause first, so it determines the kind of the failure
+func (e *attemptError) Unwrap() []error {
+	return []error{e.cause, e.err}
+}
+
+// classifyConnectError determines the kind of a connection failure
+func classifyConnectError(err error) ConnectErrorKind {
+	var handshakeErr *tlsHandshakeError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &handshakeErr):
+		return ConnectErrorTLS
+	case errors.As(err, &dnsErr):
+		return ConnectErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnectErrorRefused
+	case errors.Is(err, context.Canceled):
+		return ConnectErrorCancelled
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ConnectErrorTimeout
+	}
+	return ConnectErrorUnknown
+}
+
+// errorRecorder keeps the last error of dialing and handshaking,
+// which grpc only reports as transient failure of the connection
+type errorRecorder struct {
+	mutex sync.Mutex
+	err   error
+}
+
+func (r *errorRecorder) record(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.err = err
+}
+
+func (r *errorRecorder) last() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// dialer returns a context dialer recording its errors. It uses the custom dialer
+// of the info or connects to the address directly, grpc passes unix targets prefixed
+// with their scheme to custom dialers.
+func (r *errorRecorder) dialer(info *ConnectionInfo) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		switch {
+		case info.Dialer != nil:
+			conn, err = info.Dialer(ctx, address)
+		case strings.HasPrefix(address, "unix://"):
+			conn, err = new(net.Dialer).DialContext(ctx, "unix", strings.TrimPrefix(address, "unix://"))
+		case strings.HasPrefix(address, "unix:"):
+			conn, err = new(net.Dialer).DialContext(ctx, "unix", strings.TrimPrefix(address, "unix:"))
+		case strings.HasPrefix(address, "\x00"):
+			conn, err = new(net.Dialer).DialContext(ctx, "unix", address)
+		default:
+			conn, err = new(net.Dialer).DialContext(ctx, "tcp", address)
+		}
+		if err != nil {
+			r.record(err)
+		}
+		return conn, err
+	}
+}
+
+// recordsDialing indicates whether the recording dialer can replace the dialer of grpc.
+// Only grpc connects through the proxy configured by HTTPS_PROXY, which it ignores
+// once a dialer is set, so proxied connections keep the grpc dialer.
+func (r *errorRecorder) recordsDialing(info *ConnectionInfo) bool {
+	if info.Dialer != nil {
+		return true
+	}
+	target, err := url.Parse(info.target())
+	if err != nil {
+		return false
+	}
+	if target.Scheme == "unix" || target.Scheme == "unix-abstract" {
+		return true
+	}
+	host := strings.TrimPrefix(target.Path, "/")
+	if host == "" {
+		host = target.Opaque
+	}
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: host}})
+	return err == nil && proxy == nil
+}
+
+// recordingCredentials records failed client handshakes
+type recordingCredentials struct {
+	credentials.TransportCredentials
+	recorder *errorRecorder
+}
+
+// ClientHandshake records the error of the wrapped handshake
+func (c *recordingCredentials) ClientHandshake(ctx context.Context, authority string,
+	rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err != nil {
+		c.recorder.record(&tlsHandshakeError{err: err})
+	}
+	return conn, authInfo, err
+}
+
+// Clone keeps recording for the cloned credentials
+func (c *recordingCredentials) Clone() credentials.TransportCredentials {
+	return &recordingCredentials{TransportCredentials: c.TransportCredentials.Clone(),
+		recorder: c.recorder}
+}
@@ -0,0 +1,199 @@
+package grpcservice
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of CORSPolicy
+var (
+	// defaultCORSMethods are the methods used by grpc-web clients
+	defaultCORSMethods = []string{http.MethodPost}
+	// defaultCORSHeaders are the request headers sent by grpc-web clients
+	defaultCORSHeaders = []string{"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout",
+		"Authorization", requestIDHeader}
+	// defaultCORSExposedHeaders are the response headers grpc-web clients need to read
+	defaultCORSExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// CORSPolicy controls which browser origins may call a grpc-web server.
+// Without a policy no cross-origin request is allowed.
+type CORSPolicy struct {
+	// AllowedOrigins are exact origins like "https://app.example.com", origins with a
+	// wildcard like "https://*.example.com" matching any subdomain, or "*" for any origin.
+	// Both are matched ignoring case.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions matching whole origins
+	AllowedOriginPatterns []string
+	// AllowedMethods are the methods allowed across origins, POST by default
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed across origins, by default
+	// those sent by grpc-web clients, Authorization and the request ID
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers readable across origins,
+	// by default grpc-status, grpc-message and grpc-status-details-bin
+	ExposedHeaders []string
+	// AllowCredentials allows sending cookies and authorization headers across origins,
+	// it cannot be combined with the origin "*"
+	AllowCredentials bool
+	// MaxAge is the time browsers may cache the result of a preflight request
+	MaxAge time.Duration
+
+	patterns []*regexp.Regexp
+}
+
+// WithCORS allows cross-origin requests to grpc-web and HTTP handlers according to the policy
+func WithCORS(policy CORSPolicy) ServerOption {
+	return func(o *serverOptions) {
+		o.cors = &policy
+	}
+}
+
+// validate checks the policy and compiles its patterns
+func (p *CORSPolicy) validate() error {
+	p.patterns = nil
+	for _, pattern := range p.AllowedOriginPatterns {
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("GRPC server: Invalid CORS origin pattern %q: %v", pattern, err)
+		}
+		p.patterns = append(p.patterns, compiled)
+	}
+	for _, origin := range p.AllowedOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("GRPC server: Invalid CORS origin %q, only one wildcard is allowed", origin)
+		}
+		if origin == "*" && p.AllowCredentials {
+			return fmt.Errorf("GRPC server: CORS credentials cannot be allowed for any origin")
+		}
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("GRPC server: Negative CORS max age")
+	}
+	return nil
+}
+
+// allowsOrigin reports whether the origin is allowed
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if index := strings.Index(allowed, "*"); index >= 0 {
+			prefix, suffix := strings.ToLower(allowed[:index]), strings.ToLower(allowed[index+1:])
+			lowerOrigin := strings.ToLower(origin)
+			if len(lowerOrigin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(lowerOrigin, prefix) && strings.HasSuffix(lowerOrigin, suffix) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func orDefault(values, defaults []string) []string {
+	if len(values) > 0 {
+		return values
+	}
+	return defaults
+}
+
+// containsFold reports whether the list contains the value ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets the headers shared by preflight and actual responses
+func (p *CORSPolicy) allowOrigin(header http.Header, origin string) {
+	if containsFold(p.AllowedOrigins, "*") && !p.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers a preflight request, forbidden methods and headers are rejected
+func (p *CORSPolicy) preflight(resp http.ResponseWriter, req *http.Request, origin string) {
+	header := resp.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	if !p.allowsOrigin(origin) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	methods := orDefault(p.AllowedMethods, defaultCORSMethods)
+	if !containsFold(methods, req.Header.Get("Access-Control-Request-Method")) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	allowedHeaders := orDefault(p.AllowedHeaders, defaultCORSHeaders)
+	var requested []string
+	for _, line := range req.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if !containsFold(allowedHeaders, name) {
+				resp.WriteHeader(http.StatusForbidden)
+				return
+			}
+			requested = append(requested, name)
+		}
+	}
+
+	p.allowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if p.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// corsHandler applies the policy to the requests of the handler. Preflight requests
+// are answered directly, other requests are passed on and get CORS headers if their
+// origin is allowed. A nil policy allows no cross-origin requests.
+func corsHandler(policy *CORSPolicy, handler http.Handler) http.Handler {
+	if policy == nil {
+		policy = &CORSPolicy{}
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(resp, req)
+			return
+		}
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			policy.preflight(resp, req, origin)
+			return
+		}
+		header := resp.Header()
+		header.Add("Vary", "Origin")
+		if policy.allowsOrigin(origin) {
+			policy.allowOrigin(header, origin)
+			header.Set("Access-Control-Expose-Headers",
+				strings.Join(orDefault(policy.ExposedHeaders, defaultCORSExposedHeaders), ", "))
+		}
+		handler.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,218 @@
+package grpcservice_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+// startCORSServer starts a mux server with the CORS policy and returns its URL
+func startCORSServer(t *testing.T, policy grpcservice.CORSPolicy) string {
+	tempServer := startMuxServer(t, false, "", "", grpcservice.WithCORS(policy))
+	return "http://" + tempServer.Addr().String()
+}
+
+// preflight sends a preflight request for a grpc-web call from the origin
+func preflight(t *testing.T, url, origin, method, headers string) *http.Response {
+	request, _ := http.NewRequest(http.MethodOptions, url+"/test.Measured/Call", nil)
+	request.Header.Set("Origin", origin)
+	request.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		request.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	response, err := http.DefaultClient.Do(request)
+	test.AssertThat(t, err, nil)
+	response.Body.Close()
+	return response
+}
+
+// getFrom fetches the plain HTTP page of the mux tests from the origin
+func getFrom(t *testing.T, url, origin string) *http.Response {
+	request, _ := http.NewRequest(http.MethodGet, url+"/mux-test", nil)
+	request.Header.Set("Origin", origin)
+	response, err := http.DefaultClient.Do(request)
+	test.AssertThat(t, err, nil)
+	response.Body.Close()
+	return response
+}
+
+func TestSuiteCORS(t *testing.T) {
+	t.Run("PreflightOfAllowedOriginSucceeds", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{
+			AllowedOrigins: []string{"https://app.example.com"},
+			MaxAge:         10 * time.Minute,
+		})
+
+		// Exercise
+		response := preflight(t, url, "https://app.example.com", "POST",
+			"content-type, x-grpc-web, authorization")
+
+		// Verify
+		test.AssertThat(t, response.StatusCode, http.StatusNoContent)
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), "https://app.example.com")
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Methods"), "POST")
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Headers"),
+			"content-type, x-grpc-web, authorization")
+		test.AssertThat(t, response.Header.Get("Access-Control-Max-Age"), "600")
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Credentials"), "")
+	})
+
+	t.Run("PreflightIsForbiddenOutsideThePolicy", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}})
+
+		for name, tc := range map[string]struct {
+			origin  string
+			method  string
+			headers string
+		}{
+			"Origin":  {"https://evil.example.com", "POST", ""},
+			"Method":  {"https://app.example.com", "DELETE", ""},
+			"Headers": {"https://app.example.com", "POST", "content-type, x-secret"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				// Exercise
+				response := preflight(t, url, tc.origin, tc.method, tc.headers)
+
+				// Verify
+				test.AssertThat(t, response.StatusCode, http.StatusForbidden)
+				test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), "")
+			})
+		}
+	})
+
+	t.Run("OriginsMatchWildcardsAndPatterns", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{
+			AllowedOrigins:        []string{"https://*.example.com"},
+			AllowedOriginPatterns: []string{`http://localhost:\d+`},
+		})
+
+		for origin, allowed := range map[string]bool{
+			"https://app.example.com":  true,
+			"https://APP.Example.COM":  true,
+			"https://a.b.example.com":  true,
+			"https://.example.com":     false,
+			"https://example.com":      false,
+			"http://app.example.com":   false,
+			"http://localhost:3000":    true,
+			"http://localhost:3000.io": false,
+		} {
+			// Exercise
+			response := preflight(t, url, origin, "POST", "")
+
+			// Verify
+			if allowed {
+				test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), origin)
+			} else {
+				test.AssertThat(t, response.StatusCode, http.StatusForbidden)
+			}
+		}
+	})
+
+	t.Run("CredentialsEchoTheOrigin", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{
+			AllowedOrigins:   []string{"https://app.example.com"},
+			AllowCredentials: true,
+		})
+
+		// Exercise
+		response := getFrom(t, url, "https://app.example.com")
+
+		// Verify
+		test.AssertThat(t, response.StatusCode, http.StatusOK)
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), "https://app.example.com")
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Credentials"), "true")
+		test.AssertThat(t, response.Header.Get("Vary"), "Origin")
+	})
+
+	t.Run("AnyOriginIsAllowedWithoutCredentials", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{AllowedOrigins: []string{"*"}})
+
+		// Exercise
+		response := getFrom(t, url, "https://app.example.com")
+
+		// Verify
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), "*")
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Credentials"), "")
+	})
+
+	t.Run("ResponsesExposeGRPCStatus", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}})
+
+		// Exercise
+		response := getFrom(t, url, "https://app.example.com")
+
+		// Verify
+		exposed := response.Header.Get("Access-Control-Expose-Headers")
+		test.AssertThat(t, exposed, "Grpc-Status", "contains")
+		test.AssertThat(t, exposed, "Grpc-Message", "contains")
+	})
+
+	t.Run("DefaultPolicyAllowsNoCrossOriginRequests", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+		url := "http://" + tempServer.Addr().String()
+
+		// Exercise
+		preflightResponse := preflight(t, url, "https://app.example.com", "POST", "")
+		response := getFrom(t, url, "https://app.example.com")
+
+		// Verify
+		test.AssertThat(t, preflightResponse.StatusCode, http.StatusForbidden)
+		test.AssertThat(t, response.StatusCode, http.StatusOK)
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), "")
+		test.AssertThat(t, response.Header.Get("Access-Control-Expose-Headers"), "")
+	})
+
+	t.Run("OptionsWithoutPreflightArePassedOn", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{AllowedOrigins: []string{"*"}})
+		request, _ := http.NewRequest(http.MethodOptions, url+"/mux-test", nil)
+		request.Header.Set("Origin", "https://app.example.com")
+
+		// Exercise
+		response, err := http.DefaultClient.Do(request)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		response.Body.Close()
+		test.AssertThat(t, response.StatusCode, http.StatusOK)
+		test.AssertThat(t, response.Header.Get("Access-Control-Allow-Origin"), "*")
+	})
+
+	t.Run("GRPCWebCallsFromAllowedOriginsSucceed", func(t *testing.T) {
+		// SetUp
+		url := startCORSServer(t, grpcservice.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}})
+
+		// Exercise + Verify
+		test.AssertThat(t, callGRPCWeb(t, http.DefaultClient, url, "web"), "web")
+	})
+
+	t.Run("InvalidPoliciesFail", func(t *testing.T) {
+		for name, policy := range map[string]grpcservice.CORSPolicy{
+			"Pattern":           {AllowedOriginPatterns: []string{"https://(app"}},
+			"Wildcards":         {AllowedOrigins: []string{"https://*.*.example.com"}},
+			"AnyWithCredential": {AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			"MaxAge":            {MaxAge: -time.Second},
+		} {
+			policy := policy
+			t.Run(name, func(t *testing.T) {
+				// Exercise
+				tempServer := grpcservice.NewGRPCWebServer(false, "", "", 0,
+					grpcservice.WithHost("127.0.0.1"), grpcservice.WithCORS(policy))
+
+				// Verify
+				test.AssertThat(t, tempServer == nil, true)
+			})
+		}
+	})
+}
@@ -1,16 +1,19 @@
 package grpcservice
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
+	"path/filepath"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver/manual"
 )
 
 // ConnectionInfo describes the information necessary to connect to a grpc service
@@ -25,12 +28,85 @@ type ConnectionInfo struct {
 	RetryAfterMilliSecs int
 	KeyFile             string
 	CaFile              string
+
+	// Mutual authenticates with the client certificate CertFile and KeyFile against
+	// the certificates in CaFile, like ConnectMutual does
+	Mutual bool
+	// MinTLSVersion is tls.VersionTLS12 or tls.VersionTLS13, TLS 1.2 by default
+	MinTLSVersion uint16
+	// CipherSuites restricts the TLS 1.2 cipher suites, those of TLS 1.3 are not configurable
+	CipherSuites []uint16
+	// NextProtos are offered by ALPN in addition to h2
+	NextProtos []string
+	// InsecureSkipVerify accepts any server certificate. It is meant for development
+	// only and logs a warning with every connect.
+	InsecureSkipVerify bool
+
+	// KeepaliveTimeInMilliSecs pings the server after this idle time, at least 10000.
+	// The server has to permit it, see CheckKeepalive. 0 disables pinging.
+	KeepaliveTimeInMilliSecs int
+	// KeepaliveTimeoutInMilliSecs closes the connection if a ping is not answered in time, 20000 by default
+	KeepaliveTimeoutInMilliSecs int
+	// KeepalivePermitWithoutStream pings even without active calls
+	KeepalivePermitWithoutStream bool
+
+	// Endpoints are host:port addresses to balance calls across instead of IP and Port,
+	// they can be replaced with UpdateEndpoints once connected
+	Endpoints []string
+	// Target is any grpc target like dns:///service:443 used instead of IP and Port
+	Target string
+	// LoadBalancing is LoadBalancingPickFirst or LoadBalancingRoundRobin, the latter
+	// is the default if health checking is enabled, grpc picks the first endpoint otherwise
+	LoadBalancing string
+	// HealthCheck ejects endpoints not serving according to the grpc health protocol.
+	// It requires the round robin policy and the servers to implement grpc.health.v1.Health.
+	HealthCheck bool
+	// HealthCheckServiceName is the service whose health is checked, empty for the whole server
+	HealthCheckServiceName string
+
+	// SocketPath connects to a unix domain socket instead of IP and Port
+	SocketPath string
+	// Dialer replaces the default dialer, e.g. to connect to a custom net.Listener
+	Dialer func(ctx context.Context, address string) (net.Conn, error)
+	// Backoff controls the delays between the RetryTimes dial retries. Without it
+	// RetryAfterMilliSecs is the initial delay growing exponentially with jitter.
+	Backoff *BackoffPolicy
+	// OnRetry is called before waiting for the next dial attempt, attempt counts from 1
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// Tracer traces all calls of the connection and propagates the trace to the server
+	Tracer *Tracer
+	// CircuitBreaker fails calls fast while the server keeps failing
+	CircuitBreaker *CircuitBreaker
+	// Retry retries failed unary calls of idempotent methods
+	Retry *RetryPolicy
+	// UnaryInterceptors are run on all unary calls of the connection in the given order
+	UnaryInterceptors []grpc.UnaryClientInterceptor
+	// StreamInterceptors are run on all streaming calls of the connection in the given order
+	StreamInterceptors []grpc.StreamClientInterceptor
+}
+
+// target returns the address to dial for the given connection info
+func (info *ConnectionInfo) target() string {
+	switch {
+	case len(info.Endpoints) > 0:
+		return endpointsScheme + ":///endpoints"
+	case info.Target != "":
+		return info.Target
+	}
+	if info.SocketPath != "" {
+		if filepath.IsAbs(info.SocketPath) {
+			return "unix://" + info.SocketPath
+		}
+		return "unix:" + info.SocketPath
+	}
+	return "passthrough:///" + info.IP + ":" + info.Port
 }
 
 // The GRPCClient is a struct defining all
 // the contents needed to setup a connection to a grpc server.
 type GRPCClient struct {
 	connection *grpc.ClientConn
+	resolver   *manual.Resolver
 }
 
 // GetConnection returns a pointer to the connection instance
@@ -40,110 +116,178 @@ func (grpcclient GRPCClient) GetConnection() *grpc.ClientConn {
 
 // Connect initializes a connection with a grpc server to use by a client.
 func (grpcclient *GRPCClient) Connect(info *ConnectionInfo) error {
+	return grpcclient.ConnectContext(context.Background(), info)
+}
+
+// ConnectContext is like Connect but stops connecting once the context is done.
+// With TimeoutInMilliSecs set, it waits up to that long per attempt until the connection
+// is ready, otherwise it connects in the background. Failures are returned as *ConnectError.
+func (grpcclient *GRPCClient) ConnectContext(ctx context.Context, info *ConnectionInfo) error {
+	return grpcclient.connectTLS(ctx, info, info.Mutual)
+}
+
+// ConnectMutual initializes a connection with a grpc server with the usage of mutual tls auth
+func (grpcclient *GRPCClient) ConnectMutual(info *ConnectionInfo) error {
+	return grpcclient.ConnectMutualContext(context.Background(), info)
+}
+
+// ConnectMutualContext is like ConnectMutual but stops connecting once the context is done,
+// see ConnectContext
+func (grpcclient *GRPCClient) ConnectMutualContext(ctx context.Context, info *ConnectionInfo) error {
+	return grpcclient.connectTLS(ctx, info, true)
+}
+
+// connectTLS sets up the transport credentials and connects
+func (grpcclient *GRPCClient) connectTLS(ctx context.Context, info *ConnectionInfo, mutual bool) error {
 	log.Println("GRPC client: Initialize connection to grpc server")
-	var opts []grpc.DialOption
-	var err error
+	var creds credentials.TransportCredentials
 
 	log.Println("GRPC client: Setup connection options")
 	if info.UseTLS {
 		log.Println("GRPC client: Setup TLS connection")
-
-		var creds credentials.TransportCredentials
-		if info.CertFile != "" {
-			creds, err = credentials.
-				NewClientTLSFromFile(info.CertFile, "")
-			if err != nil {
-				log.Printf("GRPC client: Failed to create TLS credentials %v", err)
-				return err
-			}
-		} else {
-			creds = credentials.NewClientTLSFromCert(nil, "")
+		config, err := clientTLSConfig(info, mutual)
+		if err != nil {
+			log.Printf("GRPC client: Failed to create TLS credentials %v", err)
+			return &ConnectError{Kind: ConnectErrorTLS, Err: err}
 		}
-		opts = append(opts, grpc.WithTransportCredentials(creds))
+		creds = credentials.NewTLS(config)
 	} else {
-		opts = append(opts, grpc.WithInsecure())
-	}
-	if info.TimeoutInMilliSecs != 0 {
-		opts = append(opts, grpc.WithBlock())
-		opts = append(opts, grpc.WithTimeout(
-			time.Duration(info.TimeoutInMilliSecs)*time.Millisecond))
+		creds = insecure.NewCredentials()
 	}
 
-	var retryAfterMilliSecs time.Duration
-	if info.RetryAfterMilliSecs <= 0 {
-		retryAfterMilliSecs = 1000
-	} else {
-		retryAfterMilliSecs = time.Duration(info.RetryAfterMilliSecs)
-	}
-
-	return dial(grpcclient, info, retryAfterMilliSecs, opts)
+	return dial(ctx, grpcclient, info, creds)
 }
 
-// ConnectMutual initializes a connection with a grpc server with the usage of mutual tls auth
-func (grpcclient *GRPCClient) ConnectMutual(info *ConnectionInfo) error {
-	log.Println("GRPC client: Initialize connection to grpc server")
-	var opts []grpc.DialOption
-	log.Println("GRPC client: Setup connection options")
-	if info.UseTLS {
-		// load peer cert/key, cacert
-		peerCert, certKeyErr := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
-		if certKeyErr != nil {
-			log.Printf("GRPC client: load peer cert/key error: %v", certKeyErr)
-			return certKeyErr
-		}
-		caCert, readCertErr := ioutil.ReadFile(info.CaFile)
-		if readCertErr != nil {
-			log.Printf("GRPC client: read ca cert file error: %v", readCertErr)
-			return readCertErr
-		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
-		ta := credentials.NewTLS(&tls.Config{
-			Certificates: []tls.Certificate{peerCert},
-			RootCAs:      caCertPool,
-		})
-		opts = append(opts, grpc.WithTransportCredentials(ta))
-	} else {
-		opts = append(opts, grpc.WithInsecure())
+// dialOptions returns the options shared by all kinds of connections
+func dialOptions(info *ConnectionInfo) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(RequestIDUnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(RequestIDStreamClientInterceptor()),
 	}
-	if info.TimeoutInMilliSecs != 0 {
-		opts = append(opts, grpc.WithBlock())
-		opts = append(opts, grpc.WithTimeout(
-			time.Duration(info.TimeoutInMilliSecs)*time.Millisecond))
+	if info.Tracer != nil {
+		opts = append(opts,
+			grpc.WithChainUnaryInterceptor(info.Tracer.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(info.Tracer.StreamClientInterceptor()))
 	}
-
-	var retryAfterMilliSecs time.Duration
-	if info.RetryAfterMilliSecs <= 0 {
-		retryAfterMilliSecs = 1000
-	} else {
-		retryAfterMilliSecs = time.Duration(info.RetryAfterMilliSecs)
+	if info.CircuitBreaker != nil {
+		opts = append(opts,
+			grpc.WithChainUnaryInterceptor(info.CircuitBreaker.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(info.CircuitBreaker.StreamClientInterceptor()))
 	}
-
-	return dial(grpcclient, info, retryAfterMilliSecs, opts)
+	if info.Retry != nil {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(info.Retry.UnaryClientInterceptor()))
+	}
+	if len(info.UnaryInterceptors) > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(info.UnaryInterceptors...))
+	}
+	if len(info.StreamInterceptors) > 0 {
+		opts = append(opts, grpc.WithChainStreamInterceptor(info.StreamInterceptors...))
+	}
+	return opts
 }
 
+// dial connects, retrying up to info.RetryTimes times with the backoff policy of the info.
+// A backoff deadline ends the retries early, without RetryTimes it retries until the deadline.
 func dial(
+	ctx context.Context,
 	grpcclient *GRPCClient,
 	info *ConnectionInfo,
-	retryAfterMilliSecs time.Duration,
-	opts []grpc.DialOption,
+	creds credentials.TransportCredentials,
 ) error {
-	var err error
+	policy := defaultBackoffPolicy(info)
+	if info.Backoff != nil {
+		policy = *info.Backoff
+	}
+	recorder := &errorRecorder{}
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(&recordingCredentials{
+			TransportCredentials: creds, recorder: recorder}),
+	}, dialOptions(info)...)
+	if recorder.recordsDialing(info) {
+		opts = append(opts, grpc.WithContextDialer(recorder.dialer(info)))
+	}
+	serviceConfig, err := serviceConfigOption(info)
+	if err != nil {
+		return &ConnectError{Kind: ConnectErrorUnknown, Err: err}
+	}
+	if serviceConfig != nil {
+		opts = append(opts, serviceConfig)
+	}
+	keepalive, err := keepaliveOption(info)
+	if err != nil {
+		return &ConnectError{Kind: ConnectErrorUnknown, Err: err}
+	}
+	if keepalive != nil {
+		opts = append(opts, keepalive)
+	}
+	grpcclient.resolver = nil
+	if len(info.Endpoints) > 0 {
+		grpcclient.resolver = newEndpointsResolver(info.Endpoints)
+		opts = append(opts, grpc.WithResolvers(grpcclient.resolver))
+	}
+	start := time.Now()
+
 	var i int
 	for i = 0; ; i++ {
-		grpcclient.connection, err = grpc.Dial(info.IP+":"+info.Port, opts...)
+		recorder.record(nil)
+		grpcclient.connection, err = connect(ctx, info, recorder, opts)
 		if err == nil {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return &ConnectError{Kind: classifyConnectError(ctx.Err()), Err: err,
+				message: fmt.Sprintf("GRPC client: Connecting cancelled after %d attempts: %v", i+1, err)}
+		}
 
-		if i >= info.RetryTimes {
+		unlimited := info.RetryTimes == 0 && policy.Deadline > 0
+		if i >= info.RetryTimes && !unlimited {
 			break
 		}
-		time.Sleep(retryAfterMilliSecs * time.Millisecond)
-		log.Println("GRPC client: Retrying after error: ", err)
+		delay := policy.Delay(i)
+		if policy.Deadline > 0 && time.Since(start)+delay > policy.Deadline {
+			return &ConnectError{Kind: classifyConnectError(err), Err: err,
+				message: fmt.Sprintf("GRPC client: Backoff deadline of %v exceeded after %d attempts, last error: %s",
+					policy.Deadline, i+1, err)}
+		}
+		log.Printf("GRPC client: Retrying in %v after error: %v", delay, err)
+		if info.OnRetry != nil {
+			info.OnRetry(i+1, delay, err)
+		}
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return &ConnectError{Kind: classifyConnectError(sleepErr), Err: err,
+				message: fmt.Sprintf("GRPC client: Connecting cancelled after %d attempts: %v", i+1, err)}
+		}
+	}
+	return &ConnectError{Kind: classifyConnectError(err), Err: err,
+		message: fmt.Sprintf("GRPC client: After %d attempts, last error: %s", i, err)}
+}
+
+// connect creates a client connection. With TimeoutInMilliSecs set, it waits up to that long
+// for the connection to become ready. The error then names the last failure seen meanwhile.
+func connect(ctx context.Context, info *ConnectionInfo, recorder *errorRecorder,
+	opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(info.target(), opts...)
+	if err != nil || info.TimeoutInMilliSecs == 0 {
+		return conn, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx,
+		time.Duration(info.TimeoutInMilliSecs)*time.Millisecond)
+	defer cancel()
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return conn, nil
+		}
+		if state == connectivity.Shutdown || !conn.WaitForStateChange(ctx, state) {
+			conn.Close()
+			if cause := recorder.last(); cause != nil {
+				return nil, &attemptError{err: ctx.Err(), cause: cause}
+			}
+			return nil, ctx.Err()
+		}
 	}
-	return fmt.Errorf("GRPC client: After %d attempts, last error: %s", i, err)
 }
 
 //Close the grpc client connection
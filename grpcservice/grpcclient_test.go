@@ -1,12 +1,19 @@
 package grpcservice_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
 	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 /* GRPC SERVICE unit test suite */
@@ -31,8 +38,9 @@ func TestSuiteGRPCClient(t *testing.T) {
 
 		// Exercise + Verify
 		tempClient := new(grpcservice.GRPCClient)
-		err := tempClient.Connect(&grpcservice.ConnectionInfo{true, "", "",
-			"localhost", fmt.Sprint(port), 10, 1, 1, "", ""})
+		err := tempClient.Connect(&grpcservice.ConnectionInfo{UseTLS: true,
+			IP: "localhost", Port: fmt.Sprint(port), TimeoutInMilliSecs: 10,
+			RetryTimes: 1, RetryAfterMilliSecs: 1})
 		test.AssertThat(t, err,
 			"tls: first record does not look like a TLS handshake",
 			"contains", err.Error())
@@ -56,9 +64,9 @@ func TestSuiteGRPCClient(t *testing.T) {
 
 		// Exercise + Verify
 		tempClient := new(grpcservice.GRPCClient)
-		err := tempClient.Connect(&grpcservice.ConnectionInfo{true,
-			"/var/log/not-existing", ".file",
-			"localhost", fmt.Sprint(port), 10, 0, 0, "", ""})
+		err := tempClient.Connect(&grpcservice.ConnectionInfo{UseTLS: true,
+			CertFile: "/var/log/not-existing", ServerHostName: ".file",
+			IP: "localhost", Port: fmt.Sprint(port), TimeoutInMilliSecs: 10})
 		test.AssertThat(t,
 			err, "open /var/log/not-existing: no such file or directory", "streq")
 
@@ -73,8 +81,8 @@ func TestSuiteGRPCClient(t *testing.T) {
 		portCounter++
 
 		// Exercise + Verify
-		err := tempClient.Connect(&grpcservice.ConnectionInfo{false, "", "",
-			"localhost", fmt.Sprint(mainPort + portCounter), 10, 0, 0, "", ""})
+		err := tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "localhost", Port: fmt.Sprint(mainPort + portCounter), TimeoutInMilliSecs: 10})
 		test.AssertThat(t, err, "context deadline exceeded",
 			"contains", err.Error())
 	})
@@ -93,8 +101,8 @@ func TestSuiteGRPCClient(t *testing.T) {
 
 		// Exercise + Verify
 		tempClient := new(grpcservice.GRPCClient)
-		err := tempClient.Connect(&grpcservice.ConnectionInfo{false, "", "",
-			"localhost", fmt.Sprint(port), 1, 0, 0, "", ""})
+		err := tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "localhost", Port: fmt.Sprint(port), TimeoutInMilliSecs: 1})
 		test.AssertThat(t, err, nil)
 
 		// TearDown
@@ -118,8 +126,8 @@ func TestSuiteGRPCClient(t *testing.T) {
 		time.Sleep(10 * time.Microsecond)
 
 		tempClient := new(grpcservice.GRPCClient)
-		err := tempClient.Connect(&grpcservice.ConnectionInfo{false, "", "",
-			"localhost", fmt.Sprint(port), 1, 0, 0, "", ""})
+		err := tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "localhost", Port: fmt.Sprint(port), TimeoutInMilliSecs: 1})
 		test.AssertThat(t, err, nil)
 
 		// Exercise + Verify
@@ -133,4 +141,293 @@ func TestSuiteGRPCClient(t *testing.T) {
 		err = tempServer.Stop()
 		test.AssertThat(t, err, nil)
 	})
+	t.Run("ConnectingViaUnixSocketSucceeds", func(t *testing.T) {
+		// SetUp
+		socket := filepath.Join(t.TempDir(), "grpc.sock")
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithAddress("unix://"+socket))
+
+		go func() {
+			err := tempServer.Serve()
+			test.AssertThat(t, err, nil)
+		}()
+		time.Sleep(10 * time.Microsecond)
+
+		// Exercise + Verify
+		for name, info := range map[string]*grpcservice.ConnectionInfo{
+			"SocketPath": {SocketPath: socket, TimeoutInMilliSecs: 1000},
+			"Target":     {Target: "unix://" + socket, TimeoutInMilliSecs: 1000},
+		} {
+			info := info
+			t.Run(name, func(t *testing.T) {
+				tempClient := new(grpcservice.GRPCClient)
+				err := tempClient.Connect(info)
+				test.AssertThat(t, err, nil)
+
+				// TearDown
+				err = tempClient.Close()
+				test.AssertThat(t, err, nil)
+			})
+		}
+
+		// TearDown
+		err := tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("ConnectingWithCustomDialerSucceeds", func(t *testing.T) {
+		// SetUp
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"))
+		address := tempServer.Addr().String()
+
+		go func() {
+			err := tempServer.Serve()
+			test.AssertThat(t, err, nil)
+		}()
+		time.Sleep(10 * time.Microsecond)
+
+		// Exercise + Verify
+		dialed := false
+		tempClient := new(grpcservice.GRPCClient)
+		err := tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "not-resolvable.invalid", Port: "1", TimeoutInMilliSecs: 1000,
+			Dialer: func(ctx context.Context, _ string) (net.Conn, error) {
+				dialed = true
+				return (&net.Dialer{}).DialContext(ctx, "tcp", address)
+			}})
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, dialed, true)
+
+		// TearDown
+		err = tempClient.Close()
+		test.AssertThat(t, err, nil)
+
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+	t.Run("TLS", func(t *testing.T) {
+		ca := grpctest.NewCA(t, "Test CA")
+		caFile := ca.File(t)
+		serverCertFile, serverKeyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+
+		t.Run("ConnectingWithTLSSucceeds", func(t *testing.T) {
+			// SetUp
+			tempServer := grpcservice.NewGRPCServer(true, serverCertFile, serverKeyFile, 0,
+				grpcservice.WithHost("127.0.0.1"))
+			startServer(t, tempServer)
+			_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+
+			// Exercise + Verify
+			tempClient := new(grpcservice.GRPCClient)
+			err := tempClient.Connect(&grpcservice.ConnectionInfo{UseTLS: true,
+				CertFile: caFile, IP: "127.0.0.1", Port: port, TimeoutInMilliSecs: 1000})
+			test.AssertThat(t, err, nil)
+
+			// TearDown
+			test.AssertThat(t, tempClient.Close(), nil)
+			test.AssertThat(t, tempServer.Stop(), nil)
+		})
+
+		t.Run("ConnectingFailsOnExpiredServerCert", func(t *testing.T) {
+			// SetUp
+			expiredCertFile, expiredKeyFile := ca.Issue(t, grpctest.CertificateRequest{
+				Hosts: []string{"127.0.0.1"}, Server: true,
+				NotBefore: time.Now().Add(-2 * time.Hour), NotAfter: time.Now().Add(-time.Hour),
+			}).Files(t)
+			tempServer := grpcservice.NewGRPCServer(true, expiredCertFile, expiredKeyFile, 0,
+				grpcservice.WithHost("127.0.0.1"))
+			startServer(t, tempServer)
+			_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+
+			// Exercise + Verify
+			tempClient := new(grpcservice.GRPCClient)
+			err := tempClient.Connect(&grpcservice.ConnectionInfo{UseTLS: true,
+				CertFile: caFile, IP: "127.0.0.1", Port: port, TimeoutInMilliSecs: 200})
+			test.AssertThat(t, err, nil, "not")
+
+			// TearDown
+			test.AssertThat(t, tempServer.Stop(), nil)
+		})
+
+		t.Run("ConnectingMutualSucceeds", func(t *testing.T) {
+			// SetUp
+			tempServer := grpcservice.NewMutualGRPCServer(true,
+				serverCertFile, serverKeyFile, caFile, 0, grpcservice.WithHost("127.0.0.1"))
+			startServer(t, tempServer)
+			_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+			clientCertFile, clientKeyFile := ca.ClientCert(t, "client").Files(t)
+
+			// Exercise + Verify
+			tempClient := new(grpcservice.GRPCClient)
+			err := tempClient.ConnectMutual(&grpcservice.ConnectionInfo{UseTLS: true,
+				CertFile: clientCertFile, KeyFile: clientKeyFile, CaFile: caFile,
+				IP: "127.0.0.1", Port: port, TimeoutInMilliSecs: 1000})
+			test.AssertThat(t, err, nil)
+
+			// TearDown
+			test.AssertThat(t, tempClient.Close(), nil)
+			test.AssertThat(t, tempServer.Stop(), nil)
+		})
+
+		t.Run("CallsFailOnClientCertOfWrongCA", func(t *testing.T) {
+			// SetUp
+			tempServer := grpcservice.NewMutualGRPCServer(true,
+				serverCertFile, serverKeyFile, caFile, 0, grpcservice.WithHost("127.0.0.1"))
+			newMeasured().Register(tempServer.GetInstance())
+			startServer(t, tempServer)
+			_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+			clientCertFile, clientKeyFile := grpctest.NewCA(t, "Wrong CA").
+				ClientCert(t, "client").Files(t)
+
+			tempClient := new(grpcservice.GRPCClient)
+			err := tempClient.ConnectMutual(&grpcservice.ConnectionInfo{UseTLS: true,
+				CertFile: clientCertFile, KeyFile: clientKeyFile, CaFile: caFile,
+				IP: "127.0.0.1", Port: port})
+			test.AssertThat(t, err, nil)
+			defer tempClient.Close()
+
+			// Exercise
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = grpctest.Invoke(ctx, tempClient.GetConnection(), "test.Measured", "Call", nil)
+
+			// Verify
+			test.AssertThat(t, status.Code(err), codes.Unavailable)
+			test.AssertThat(t, err, "certificate", "contains")
+
+			// TearDown
+			test.AssertThat(t, tempServer.Stop(), nil)
+		})
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+
+		t.Run("RetriesAreReportedWithGrowingDelays", func(t *testing.T) {
+			// SetUp
+			var attempts []int
+			var delays []time.Duration
+
+			// Exercise
+			tempClient := new(grpcservice.GRPCClient)
+			err := tempClient.Connect(&grpcservice.ConnectionInfo{
+				IP: "127.0.0.1", Port: closedPort, TimeoutInMilliSecs: 10, RetryTimes: 3,
+				Backoff: &grpcservice.BackoffPolicy{Initial: time.Millisecond, Multiplier: 2},
+				OnRetry: func(attempt int, delay time.Duration, err error) {
+					attempts = append(attempts, attempt)
+					delays = append(delays, delay)
+				}})
+
+			// Verify
+			test.AssertThat(t, err, "After 3 attempts", "contains")
+			test.AssertThat(t, len(attempts), 3)
+			for i, expected := range []time.Duration{time.Millisecond, 2 * time.Millisecond,
+				4 * time.Millisecond} {
+				test.AssertThat(t, attempts[i], i+1)
+				test.AssertThat(t, delays[i], expected)
+			}
+		})
+
+		t.Run("DeadlineEndsRetries", func(t *testing.T) {
+			// Exercise
+			tempClient := new(grpcservice.GRPCClient)
+			start := time.Now()
+			err := tempClient.Connect(&grpcservice.ConnectionInfo{
+				IP: "127.0.0.1", Port: closedPort, TimeoutInMilliSecs: 10,
+				Backoff: &grpcservice.BackoffPolicy{Initial: 20 * time.Millisecond,
+					Deadline: 100 * time.Millisecond}})
+
+			// Verify
+			test.AssertThat(t, err, "Backoff deadline of 100ms exceeded", "contains")
+			test.AssertThat(t, time.Since(start) < time.Second, true)
+		})
+
+		t.Run("ContextCancelsRetries", func(t *testing.T) {
+			// SetUp
+			ctx, cancel := context.WithCancel(context.Background())
+
+			// Exercise
+			tempClient := new(grpcservice.GRPCClient)
+			err := tempClient.ConnectContext(ctx, &grpcservice.ConnectionInfo{
+				IP: "127.0.0.1", Port: closedPort, TimeoutInMilliSecs: 10, RetryTimes: 100,
+				Backoff: &grpcservice.BackoffPolicy{Initial: time.Hour},
+				OnRetry: func(attempt int, delay time.Duration, err error) {
+					cancel()
+				}})
+
+			// Verify
+			test.AssertThat(t, err, "Connecting cancelled after 1 attempts", "contains")
+		})
+	})
+
+	t.Run("ConnectErrors", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+		silent, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer silent.Close()
+		_, silentPort, _ := net.SplitHostPort(silent.Addr().String())
+		plainServer := grpcservice.NewGRPCServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+		startServer(t, plainServer)
+		defer plainServer.Stop()
+		_, plainPort, _ := net.SplitHostPort(plainServer.Addr().String())
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		for _, tc := range []struct {
+			name     string
+			ctx      context.Context
+			info     *grpcservice.ConnectionInfo
+			expected grpcservice.ConnectErrorKind
+		}{
+			{"Refused", context.Background(), &grpcservice.ConnectionInfo{
+				IP: "127.0.0.1", Port: closedPort}, grpcservice.ConnectErrorRefused},
+			{"DNS", context.Background(), &grpcservice.ConnectionInfo{
+				IP: "not-existing.invalid", Port: "443"}, grpcservice.ConnectErrorDNS},
+			{"Timeout", context.Background(), &grpcservice.ConnectionInfo{
+				IP: "127.0.0.1", Port: silentPort}, grpcservice.ConnectErrorTimeout},
+			{"TLSHandshake", context.Background(), &grpcservice.ConnectionInfo{UseTLS: true,
+				IP: "127.0.0.1", Port: plainPort}, grpcservice.ConnectErrorTLS},
+			{"TLSSetup", context.Background(), &grpcservice.ConnectionInfo{UseTLS: true,
+				CertFile: "/var/log/not-existing", IP: "127.0.0.1", Port: plainPort},
+				grpcservice.ConnectErrorTLS},
+			{"Cancelled", cancelled, &grpcservice.ConnectionInfo{
+				IP: "127.0.0.1", Port: closedPort}, grpcservice.ConnectErrorCancelled},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				// SetUp
+				tc.info.TimeoutInMilliSecs = 200
+				tempClient := new(grpcservice.GRPCClient)
+
+				// Exercise
+				err := tempClient.ConnectContext(tc.ctx, tc.info)
+
+				// Verify
+				var connectErr *grpcservice.ConnectError
+				test.AssertThat(t, errors.As(err, &connectErr), true)
+				test.AssertThat(t, connectErr.Kind.String(), tc.expected.String())
+			})
+		}
+	})
+
+	t.Run("ConnectContextHonoursDeadline", func(t *testing.T) {
+		// SetUp
+		silent, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer silent.Close()
+		_, port, _ := net.SplitHostPort(silent.Addr().String())
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+
+		// Exercise
+		err := new(grpcservice.GRPCClient).ConnectContext(ctx, &grpcservice.ConnectionInfo{
+			IP: "127.0.0.1", Port: port, TimeoutInMilliSecs: 5000})
+
+		// Verify
+		test.AssertThat(t, err, nil, "not")
+		test.AssertThat(t, time.Since(start) < time.Second, true)
+	})
 }
@@ -0,0 +1,269 @@
+package grpcservice
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+// The GRPCMuxServer serves native grpc, grpc-web and plain HTTP on a single port.
+// Services are registered once on GetInstance and are reachable by all kinds of clients.
+// Native grpc is detected by HTTP/2 and its content type, without TLS it is served as h2c.
+type GRPCMuxServer struct {
+	innerServer  *grpc.Server
+	webServer    *grpcweb.WrappedGrpcServer
+	httpServer   *http.Server
+	listener     net.Listener
+	certificates *CertificateProvider
+	useTLS       bool
+	certFile     string
+	keyFile      string
+
+	nativeCalls *callTracker
+
+	mutex     sync.Mutex
+	isRunning bool
+}
+
+// callTracker counts the running native grpc calls. Without TLS their connections are
+// taken over by h2c, so http.Server.Shutdown does not wait for them.
+type callTracker struct {
+	mutex    sync.Mutex
+	draining bool
+	running  sync.WaitGroup
+}
+
+// begin registers a call, it fails once the tracker drains
+func (c *callTracker) begin() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.draining {
+		return false
+	}
+	c.running.Add(1)
+	return true
+}
+
+// end unregisters a call
+func (c *callTracker) end() {
+	c.running.Done()
+}
+
+// drain rejects new calls from now on
+func (c *callTracker) drain() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.draining = true
+}
+
+// wait waits for the running calls until the context ends
+func (c *callTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewGRPCMuxServer initializes a server struct offering a service to grpc, grpc-web and HTTP clients.
+// A port of 0 binds to an ephemeral port, see Addr for the actual address.
+func NewGRPCMuxServer(useTLS bool, certFile string, keyFile string, port int,
+	options ...ServerOption) *GRPCMuxServer {
+	serverOptions := newServerOptions(options)
+	if err := serverOptions.validate(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	if err := serverOptions.validateHTTP(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	listener, err := serverOptions.listen(port)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	server := grpc.NewServer(serverOptions.grpcOptions()...)
+	webServer := grpcweb.WrapServer(server)
+	log.Print("GRPC Mux server: Listening on ", listener.Addr())
+
+	nativeCalls := &callTracker{}
+	handler := serverOptions.wrapHTTPHandler(
+		muxHandler(server, webServer, nativeCalls, serverOptions.cors, serverOptions.fallbackHandler()))
+	h2Server := &http2.Server{}
+	if useTLS {
+		log.Print("GRPC Mux server: Preparing server (with TLS)")
+	} else {
+		log.Print("GRPC Mux server: Preparing server (without TLS)")
+		handler = h2c.NewHandler(handler, h2Server)
+	}
+	httpServer := serverOptions.newHTTPServer(handler, useTLS)
+	// lets Shutdown send GOAWAY to h2c connections as well
+	if err := http2.ConfigureServer(httpServer, h2Server); err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return &GRPCMuxServer{
+		innerServer:  server,
+		webServer:    webServer,
+		httpServer:   httpServer,
+		listener:     listener,
+		certificates: serverOptions.certificates,
+		useTLS:       useTLS,
+		certFile:     certFile,
+		keyFile:      keyFile,
+		nativeCalls:  nativeCalls,
+	}
+}
+
+// isGRPCContentType reports whether the content type is one of native grpc, but not grpc-web
+func isGRPCContentType(contentType string) bool {
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// muxHandler answers native grpc requests and passes everything else to the grpc-web handler.
+// Native calls are exempt from the HTTP read and write timeouts, which would end long streams.
+func muxHandler(server *grpc.Server, webServer *grpcweb.WrappedGrpcServer, nativeCalls *callTracker,
+	cors *CORSPolicy, fallback http.Handler) http.Handler {
+	webHandler := grpcWebHandler(webServer, cors, fallback)
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor == 2 && isGRPCContentType(req.Header.Get("Content-Type")) {
+			if !nativeCalls.begin() {
+				http.Error(resp, "GRPCMux server: Shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			defer nativeCalls.end()
+			controller := http.NewResponseController(resp)
+			controller.SetReadDeadline(time.Time{})
+			controller.SetWriteDeadline(time.Time{})
+			server.ServeHTTP(resp, req)
+			return
+		}
+		webHandler.ServeHTTP(resp, req)
+	})
+}
+
+// Serve starts serving on the listener until the server is stopped
+func (grpcserver *GRPCMuxServer) Serve() error {
+	if grpcserver == nil || grpcserver.innerServer == nil {
+		return fmt.Errorf("GRPCMux server: Is not initialized")
+	}
+	grpcserver.mutex.Lock()
+	if grpcserver.isRunning {
+		grpcserver.mutex.Unlock()
+		return fmt.Errorf("GRPCMux server: Instance is already running")
+	}
+	grpcserver.isRunning = true
+	grpcserver.mutex.Unlock()
+
+	var err error
+	if !grpcserver.useTLS {
+		err = grpcserver.httpServer.Serve(grpcserver.listener)
+	} else if grpcserver.certificates != nil {
+		err = grpcserver.httpServer.ServeTLS(grpcserver.listener, "", "")
+	} else {
+		err = grpcserver.httpServer.ServeTLS(grpcserver.listener,
+			grpcserver.certFile, grpcserver.keyFile)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Stop closes the listener and all connections
+func (grpcserver *GRPCMuxServer) Stop() error {
+	if grpcserver == nil || grpcserver.innerServer == nil {
+		return fmt.Errorf("GRPCMux server: Is not initialized")
+	}
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
+	if !grpcserver.isRunning {
+		return fmt.Errorf("GRPCMux server: Is not running")
+	}
+
+	grpcserver.innerServer.Stop()
+	err := grpcserver.httpServer.Close()
+	grpcserver.isRunning = false
+	return err
+}
+
+// Shutdown stops accepting connections and calls and waits for running native grpc,
+// grpc-web and HTTP requests until the context ends. Remaining connections are closed
+// afterwards and the error of the context is returned. The server keeps running while
+// draining, so Stop can still close it right away.
+func (grpcserver *GRPCMuxServer) Shutdown(ctx context.Context) error {
+	if grpcserver == nil || grpcserver.innerServer == nil {
+		return fmt.Errorf("GRPCMux server: Is not initialized")
+	}
+	grpcserver.mutex.Lock()
+	if !grpcserver.isRunning {
+		grpcserver.mutex.Unlock()
+		return fmt.Errorf("GRPCMux server: Is not running")
+	}
+	grpcserver.nativeCalls.drain()
+	grpcserver.mutex.Unlock()
+
+	err := grpcserver.httpServer.Shutdown(ctx)
+	if err == nil {
+		err = grpcserver.nativeCalls.wait(ctx)
+	}
+
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
+	grpcserver.innerServer.Stop()
+	if err != nil {
+		grpcserver.httpServer.Close()
+	}
+	grpcserver.isRunning = false
+	return err
+}
+
+// IsRunning indicates if the server started listening properly
+func (grpcserver *GRPCMuxServer) IsRunning() bool {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
+	return grpcserver.isRunning
+}
+
+// Addr returns the address the server is bound to or nil if it is not initialized
+func (grpcserver *GRPCMuxServer) Addr() net.Addr {
+	if grpcserver.listener == nil {
+		return nil
+	}
+	return grpcserver.listener.Addr()
+}
+
+// IsInitialized indicates if the server was initialized properly
+func (grpcserver *GRPCMuxServer) IsInitialized() bool {
+	return grpcserver.innerServer != nil
+}
+
+// GetInstance returns the grpc server to register services on
+func (grpcserver *GRPCMuxServer) GetInstance() *grpc.Server {
+	return grpcserver.innerServer
+}
+
+// GetWebInstance returns the grpc-web wrapper of the grpc server
+func (grpcserver *GRPCMuxServer) GetWebInstance() *grpcweb.WrappedGrpcServer {
+	return grpcserver.webServer
+}
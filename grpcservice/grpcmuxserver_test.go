@@ -0,0 +1,293 @@
+package grpcservice_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// muxHTTPOnce registers the plain HTTP handler of the mux tests on the default mux once
+var muxHTTPOnce sync.Once
+
+// startMuxServer starts a mux server offering the measured service and a plain HTTP page
+func startMuxServer(t *testing.T, useTLS bool, certFile, keyFile string,
+	options ...grpcservice.ServerOption) *grpcservice.GRPCMuxServer {
+	muxHTTPOnce.Do(func() {
+		http.HandleFunc("/mux-test", func(resp http.ResponseWriter, req *http.Request) {
+			io.WriteString(resp, "plain "+req.Proto)
+		})
+	})
+	tempServer := grpcservice.NewGRPCMuxServer(useTLS, certFile, keyFile, 0,
+		append([]grpcservice.ServerOption{grpcservice.WithHost("127.0.0.1")}, options...)...)
+	newMeasured().Register(tempServer.GetInstance())
+	go tempServer.Serve()
+	deadline := time.Now().Add(time.Second)
+	for !tempServer.IsRunning() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	t.Cleanup(func() {
+		tempServer.Stop()
+	})
+	return tempServer
+}
+
+// grpcWebRequest creates a grpc-web request calling the measured service with the value
+func grpcWebRequest(t *testing.T, url string, value string) *http.Request {
+	request, _ := structpb.NewStruct(map[string]interface{}{"value": value})
+	message, err := proto.Marshal(request)
+	test.AssertThat(t, err, nil)
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	httpRequest, _ := http.NewRequest(http.MethodPost, url+"/test.Measured/Call", bytes.NewReader(frame))
+	httpRequest.Header.Set("Content-Type", "application/grpc-web+proto")
+	httpRequest.Header.Set("X-Grpc-Web", "1")
+	return httpRequest
+}
+
+// callGRPCWeb calls the measured service with a grpc-web request and returns the echoed value
+func callGRPCWeb(t *testing.T, client *http.Client, url string, value string) string {
+	response, err := client.Do(grpcWebRequest(t, url, value))
+	test.AssertThat(t, err, nil)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	test.AssertThat(t, err, nil)
+	test.AssertThat(t, response.StatusCode, http.StatusOK)
+	test.AssertThat(t, len(body) > 5, true)
+
+	length := binary.BigEndian.Uint32(body[1:5])
+	reply := new(structpb.Struct)
+	test.AssertThat(t, proto.Unmarshal(body[5:5+length], reply), nil)
+	test.AssertThat(t, string(body[5+length:]), "grpc-status: 0", "contains")
+	return reply.Fields["value"].GetStringValue()
+}
+
+// getPlain fetches the plain HTTP page of the mux tests
+func getPlain(t *testing.T, client *http.Client, url string) string {
+	response, err := client.Get(url + "/mux-test")
+	test.AssertThat(t, err, nil)
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	return string(body)
+}
+
+// callNative calls the measured service with a native grpc client and returns the echoed value
+func callNative(t *testing.T, info *grpcservice.ConnectionInfo, value string) string {
+	tempClient := new(grpcservice.GRPCClient)
+	test.AssertThat(t, tempClient.Connect(info), nil)
+	defer tempClient.Close()
+	request, _ := structpb.NewStruct(map[string]interface{}{"value": value})
+	reply, err := grpctest.Invoke(context.Background(), tempClient.GetConnection(),
+		"test.Measured", "Call", request)
+	test.AssertThat(t, err, nil)
+	return reply.Fields["value"].GetStringValue()
+}
+
+// openEcho opens a native echo stream to the mux server and checks it answers
+func openEcho(t *testing.T, tempServer *grpcservice.GRPCMuxServer) grpc.ClientStream {
+	_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+	tempClient := new(grpcservice.GRPCClient)
+	test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{IP: "127.0.0.1", Port: port}), nil)
+	t.Cleanup(func() {
+		tempClient.Close()
+	})
+	stream, err := grpctest.NewStream(context.Background(), tempClient.GetConnection(),
+		"test.Measured", "Stream")
+	test.AssertThat(t, err, nil)
+	echo(t, stream, "first")
+	return stream
+}
+
+// echo sends the value on the stream and checks it is sent back
+func echo(t *testing.T, stream grpc.ClientStream, value string) {
+	request, _ := structpb.NewStruct(map[string]interface{}{"value": value})
+	test.AssertThat(t, stream.SendMsg(request), nil)
+	reply := new(structpb.Struct)
+	test.AssertThat(t, stream.RecvMsg(reply), nil)
+	test.AssertThat(t, reply.Fields["value"].GetStringValue(), value)
+}
+
+func TestSuiteGRPCMuxServer(t *testing.T) {
+	t.Run("ServeWithoutSetupFails", func(t *testing.T) {
+		// Exercise + Verify
+		test.AssertThat(t, (&grpcservice.GRPCMuxServer{}).Serve(), "GRPCMux server: Is not initialized", "streq")
+		test.AssertThat(t, (&grpcservice.GRPCMuxServer{}).Stop(), "GRPCMux server: Is not initialized", "streq")
+	})
+
+	t.Run("StopFailsWhenServerIsNotRunning", func(t *testing.T) {
+		// SetUp
+		tempServer := grpcservice.NewGRPCMuxServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+
+		// Exercise + Verify
+		test.AssertThat(t, tempServer.Stop(), "GRPCMux server: Is not running", "streq")
+	})
+
+	t.Run("RunningTwiceIsNotPossible", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+
+		// Exercise + Verify
+		test.AssertThat(t, tempServer.Serve(), "GRPCMux server: Instance is already running", "streq")
+	})
+
+	t.Run("AllProtocolsAreServedWithoutTLS", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+		url := "http://" + tempServer.Addr().String()
+
+		// Exercise + Verify
+		test.AssertThat(t, callNative(t, &grpcservice.ConnectionInfo{IP: "127.0.0.1", Port: port},
+			"native"), "native")
+		test.AssertThat(t, callGRPCWeb(t, http.DefaultClient, url, "web"), "web")
+		test.AssertThat(t, getPlain(t, http.DefaultClient, url), "plain HTTP/1.1")
+	})
+
+	t.Run("AllProtocolsAreServedWithTLS", func(t *testing.T) {
+		// SetUp
+		ca := grpctest.NewCA(t, "Test CA")
+		certFile, keyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+		tempServer := startMuxServer(t, true, certFile, keyFile)
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+		url := "https://" + tempServer.Addr().String()
+		http1 := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.CertPool()}}}
+		http2 := &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true,
+			TLSClientConfig: &tls.Config{RootCAs: ca.CertPool()}}}
+
+		// Exercise + Verify
+		test.AssertThat(t, callNative(t, &grpcservice.ConnectionInfo{UseTLS: true, CertFile: ca.File(t),
+			IP: "127.0.0.1", Port: port}, "native"), "native")
+		test.AssertThat(t, callGRPCWeb(t, http1, url, "web"), "web")
+		test.AssertThat(t, callGRPCWeb(t, http2, url, "web over h2"), "web over h2")
+		test.AssertThat(t, getPlain(t, http1, url), "plain HTTP/1.1")
+		test.AssertThat(t, getPlain(t, http2, url), "plain HTTP/2.0")
+	})
+
+	t.Run("NativeCallsAreServedWithCertificateProvider", func(t *testing.T) {
+		// SetUp
+		ca := grpctest.NewCA(t, "Test CA")
+		certFile, keyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+		provider, err := grpcservice.NewCertificateProvider(certFile, keyFile, "", 0, nil)
+		test.AssertThat(t, err, nil)
+		// the provider takes precedence over the files
+		tempServer := startMuxServer(t, true, "missing.pem", "missing.key",
+			grpcservice.WithCertificateProvider(provider))
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+
+		// Exercise + Verify
+		test.AssertThat(t, callNative(t, &grpcservice.ConnectionInfo{UseTLS: true, CertFile: ca.File(t),
+			IP: "127.0.0.1", Port: port}, "native"), "native")
+	})
+
+	t.Run("StopEndsServing", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+
+		// Exercise
+		test.AssertThat(t, tempServer.Stop(), nil)
+
+		// Verify
+		test.AssertThat(t, tempServer.IsRunning(), false)
+		_, err := net.Dial("tcp", tempServer.Addr().String())
+		test.AssertThat(t, err, nil, "not")
+	})
+
+	t.Run("ShutdownDrainsAndEndsServing", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+		url := "http://" + tempServer.Addr().String()
+		test.AssertThat(t, callGRPCWeb(t, http.DefaultClient, url, "web"), "web")
+
+		// Exercise
+		err := tempServer.Shutdown(context.Background())
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, tempServer.IsRunning(), false)
+		test.AssertThat(t, tempServer.Shutdown(context.Background()), "GRPCMux server: Is not running", "streq")
+		_, err = net.Dial("tcp", tempServer.Addr().String())
+		test.AssertThat(t, err, nil, "not")
+	})
+	t.Run("ShutdownDrainsNativeStreams", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+		stream := openEcho(t, tempServer)
+
+		// Exercise
+		shutdown := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			shutdown <- tempServer.Shutdown(ctx)
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		// Verify
+		select {
+		case err := <-shutdown:
+			t.Fatalf("Shutdown did not wait for the stream: %v", err)
+		default:
+		}
+		echo(t, stream, "second")
+		test.AssertThat(t, stream.CloseSend(), nil)
+		test.AssertThat(t, stream.RecvMsg(new(structpb.Struct)), io.EOF)
+		test.AssertThat(t, <-shutdown, nil)
+	})
+
+	t.Run("StopIsNotBlockedByShutdown", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "")
+		openEcho(t, tempServer)
+		shutdown := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			shutdown <- tempServer.Shutdown(ctx)
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		// Exercise
+		test.AssertThat(t, tempServer.IsRunning(), true)
+		err := tempServer.Stop()
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		select {
+		case <-shutdown:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown did not end after Stop")
+		}
+		test.AssertThat(t, tempServer.IsRunning(), false)
+	})
+
+	t.Run("WriteTimeoutSparesNativeStreams", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "",
+			grpcservice.WithHTTPTimeouts(grpcservice.HTTPTimeouts{Write: 100 * time.Millisecond}))
+		stream := openEcho(t, tempServer)
+
+		// Exercise
+		time.Sleep(300 * time.Millisecond)
+
+		// Verify
+		echo(t, stream, "second")
+		test.AssertThat(t, stream.CloseSend(), nil)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -14,23 +15,35 @@ import (
 
 // The GRPCServer is a struct defining all the contents needed to setup a grpc server.
 type GRPCServer struct {
+	mutex     sync.Mutex
 	server    *grpc.Server
 	listener  net.Listener
 	isRunning bool
 }
 
-// NewGRPCServer initializes the server struct offering a service
-func NewGRPCServer(useTLS bool, certFile string, keyFile string, port int) *GRPCServer {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// NewGRPCServer initializes the server struct offering a service.
+// A port of 0 binds to an ephemeral port, see Addr for the actual address.
+func NewGRPCServer(useTLS bool, certFile string, keyFile string, port int,
+	options ...ServerOption) *GRPCServer {
+	serverOptions := newServerOptions(options)
+	if err := serverOptions.validate(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	listener, err := serverOptions.listen(port)
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
-	log.Print("GRPC server: Listening on port ", port)
+	log.Print("GRPC server: Listening on ", listener.Addr())
 
 	var opts []grpc.ServerOption
 
-	if useTLS {
+	if useTLS && serverOptions.certificates != nil {
+		log.Print("GRPC server: Prepare server options (with reloadable TLS)")
+		creds := credentials.NewTLS(serverOptions.certificates.TLSConfig(false))
+		opts = []grpc.ServerOption{grpc.Creds(creds)}
+	} else if useTLS {
 		log.Print("GRPC server: Prepare server options (with TLS)")
 		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
 		if err != nil {
@@ -44,15 +57,29 @@ func NewGRPCServer(useTLS bool, certFile string, keyFile string, port int) *GRPC
 	}
 
 	log.Print("GRPC server: Creating new RPC server")
+	opts = append(opts, serverOptions.grpcOptions()...)
 	server := grpc.NewServer(opts...)
 
 	return &GRPCServer{server: server, listener: listener, isRunning: false}
 }
 
 // NewMutualGRPCServer initializes the server struct including mutual tls auth for offering a service
-func NewMutualGRPCServer(useTLS bool, certFile string, keyFile string, caFile string, port int) *GRPCServer {
+func NewMutualGRPCServer(useTLS bool, certFile string, keyFile string, caFile string, port int,
+	options ...ServerOption) *GRPCServer {
+	serverOptions := newServerOptions(options)
+	if err := serverOptions.validate(); err != nil {
+		log.Println(err)
+		return nil
+	}
 	var opts []grpc.ServerOption
-	if useTLS {
+	if useTLS && serverOptions.certificates != nil {
+		if !serverOptions.certificates.hasClientCAs() {
+			log.Println(errMissingClientCAs)
+			return nil
+		}
+		ta := credentials.NewTLS(serverOptions.certificates.TLSConfig(true))
+		opts = []grpc.ServerOption{grpc.Creds(ta)}
+	} else if useTLS {
 		// load peer cert/key, ca cert
 		peerCert, err := tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
@@ -76,38 +103,45 @@ func NewMutualGRPCServer(useTLS bool, certFile string, keyFile string, caFile st
 		opts = []grpc.ServerOption{grpc.Creds(ta)}
 	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	listener, err := serverOptions.listen(port)
 	if err != nil {
 		log.Printf("GRPC server: listen on port %d error:%v", port, err)
 		return nil
 	}
 
+	opts = append(opts, serverOptions.grpcOptions()...)
 	server := grpc.NewServer(opts...)
 	return &GRPCServer{server: server, listener: listener, isRunning: false}
 }
 
 // Serve registers the server as grpc server and starts it with the given listener
 func (grpcserver *GRPCServer) Serve() error {
-
+	grpcserver.mutex.Lock()
 	if grpcserver.server == nil {
+		grpcserver.mutex.Unlock()
 		return fmt.Errorf("GRPC server: Is not initialized")
 	}
-	if grpcserver.IsRunning() == true {
+	if grpcserver.isRunning {
+		grpcserver.mutex.Unlock()
 		return fmt.Errorf("GRPC server: Instance is already running")
 	}
-
 	grpcserver.isRunning = true
-	grpcserver.server.Serve(grpcserver.listener)
+	server, listener := grpcserver.server, grpcserver.listener
+	grpcserver.mutex.Unlock()
+
+	server.Serve(listener)
 	return nil
 }
 
 //Stop the grpc server
 func (grpcserver *GRPCServer) Stop() error {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	if grpcserver.server == nil {
 		return fmt.Errorf("GRPC server: Is not initialized")
 	}
 
-	if grpcserver.IsRunning() == false {
+	if !grpcserver.isRunning {
 		return fmt.Errorf("GRPC server: Is not running")
 	}
 
@@ -121,16 +155,32 @@ func (grpcserver *GRPCServer) Stop() error {
 }
 
 //IsRunning indicates if the server started listening properly
-func (grpcserver GRPCServer) IsRunning() bool {
+func (grpcserver *GRPCServer) IsRunning() bool {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	return grpcserver.isRunning
 }
 
+//Addr returns the address the server is bound to or nil if it is not initialized
+func (grpcserver *GRPCServer) Addr() net.Addr {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
+	if grpcserver.listener == nil {
+		return nil
+	}
+	return grpcserver.listener.Addr()
+}
+
 //IsInitialized indicates if the server was initialized properly
-func (grpcserver GRPCServer) IsInitialized() bool {
+func (grpcserver *GRPCServer) IsInitialized() bool {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	return (nil != grpcserver.server)
 }
 
 //GetInstance returns a pointer to server instance
-func (grpcserver GRPCServer) GetInstance() *grpc.Server {
+func (grpcserver *GRPCServer) GetInstance() *grpc.Server {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	return grpcserver.server
 }
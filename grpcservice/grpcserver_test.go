@@ -2,6 +2,9 @@ package grpcservice_test
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -103,4 +106,108 @@ func TestSuiteGRPCServer(t *testing.T) {
 		// TearDown
 		test.AssertThat(t, grpcservice.GetInstanceFromServer(tempServer), instance)
 	})
+	t.Run("AddrIsNilWhenServerIsNotInitialized", func(t *testing.T) {
+		tempServer := &grpcservice.GRPCServer{}
+		test.AssertThat(t, tempServer.Addr(), nil)
+	})
+
+	t.Run("EphemeralPortIsExposedByAddr", func(t *testing.T) {
+		// SetUp
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"))
+
+		// Exercise + Verify
+		addr, ok := tempServer.Addr().(*net.TCPAddr)
+		test.AssertThat(t, ok, true)
+		test.AssertThat(t, addr.IP.String(), "127.0.0.1")
+		test.AssertThat(t, addr.Port, 0, "not")
+
+		// TearDown
+		startServer(t, tempServer)
+		err := tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("CreateServerFailsOnUnknownHost", func(t *testing.T) {
+		var nilServer *grpcservice.GRPCServer
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithHost("256.0.0.1"))
+		test.AssertThat(t, tempServer, nilServer)
+	})
+	t.Run("ServingOnUnixSocketSucceeds", func(t *testing.T) {
+		// SetUp
+		socket := filepath.Join(t.TempDir(), "grpc.sock")
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithAddress("unix://"+socket),
+			grpcservice.WithSocketPermissions(0600))
+		test.AssertThat(t, tempServer.Addr().Network(), "unix")
+		test.AssertThat(t, tempServer.Addr().String(), socket)
+
+		// Exercise + Verify
+		info, err := os.Stat(socket)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, info.Mode().Perm(), os.FileMode(0600))
+		entries, err := os.ReadDir(filepath.Dir(socket))
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, len(entries), 1)
+		startServer(t, tempServer)
+		conn, err := net.Dial("unix", socket)
+		test.AssertThat(t, err, nil)
+		conn.Close()
+
+		// TearDown
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+		_, err = os.Stat(socket)
+		test.AssertThat(t, os.IsNotExist(err), true)
+	})
+
+	t.Run("StaleUnixSocketIsRemoved", func(t *testing.T) {
+		// SetUp
+		socket := filepath.Join(t.TempDir(), "grpc.sock")
+		stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+		test.AssertThat(t, err, nil)
+		stale.SetUnlinkOnClose(false)
+		stale.Close()
+
+		// Exercise + Verify
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithAddress("unix://"+socket))
+		test.AssertThat(t, tempServer.IsInitialized(), true)
+
+		// TearDown
+		startServer(t, tempServer)
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("CreateServerFailsOnUnixSocketInUse", func(t *testing.T) {
+		// SetUp
+		var nilServer *grpcservice.GRPCServer
+		socket := filepath.Join(t.TempDir(), "grpc.sock")
+		inUse, err := net.Listen("unix", socket)
+		test.AssertThat(t, err, nil)
+		defer inUse.Close()
+
+		// Exercise + Verify
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithAddress("unix://"+socket))
+		test.AssertThat(t, tempServer, nilServer)
+	})
+
+	t.Run("ServingOnCustomListenerSucceeds", func(t *testing.T) {
+		// SetUp
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		test.AssertThat(t, err, nil)
+
+		// Exercise + Verify
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0,
+			grpcservice.WithListener(listener))
+		test.AssertThat(t, tempServer.Addr(), listener.Addr())
+
+		// TearDown
+		startServer(t, tempServer)
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
 }
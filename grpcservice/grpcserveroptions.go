@@ -0,0 +1,176 @@
+package grpcservice
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// unixScheme prefixes addresses referring to unix domain sockets
+const unixScheme = "unix://"
+
+// ServerOption configures optional behaviour of GRPCServer and GRPCWebServer
+type ServerOption func(*serverOptions)
+
+// serverOptions collects everything configurable through ServerOption
+type serverOptions struct {
+	host         string
+	address      string
+	listener     net.Listener
+	socketPerms  os.FileMode
+	certificates *CertificateProvider
+	keepalive    *ServerKeepalive
+	cors         *CORSPolicy
+	httpTimeouts HTTPTimeouts
+
+	httpHandler    http.Handler
+	httpMiddleware []HTTPMiddleware
+	accessLog      io.Writer
+
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+}
+
+// WithHost binds the server to the given host or IP instead of all interfaces
+func WithHost(host string) ServerOption {
+	return func(o *serverOptions) {
+		o.host = host
+	}
+}
+
+// WithAddress binds the server to the given address instead of host and port.
+// Addresses of the form unix:///path/to/socket create a unix domain socket,
+// anything else is treated as a TCP host:port pair.
+func WithAddress(address string) ServerOption {
+	return func(o *serverOptions) {
+		o.address = address
+	}
+}
+
+// WithListener serves on an already opened listener, host, port and address are ignored
+func WithListener(listener net.Listener) ServerOption {
+	return func(o *serverOptions) {
+		o.listener = listener
+	}
+}
+
+// WithSocketPermissions sets the file permissions of a unix domain socket
+// created through WithAddress, e.g. 0660 to restrict access to a group
+func WithSocketPermissions(perms os.FileMode) ServerOption {
+	return func(o *serverOptions) {
+		o.socketPerms = perms
+	}
+}
+
+// WithCertificateProvider serves TLS certificates from the provider instead of
+// the certificate files given to the constructor, which allows reloading them
+func WithCertificateProvider(provider *CertificateProvider) ServerOption {
+	return func(o *serverOptions) {
+		o.certificates = provider
+	}
+}
+
+// WithUnaryInterceptors adds interceptors to all unary calls, they are run in the given order
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds interceptors to all streaming calls, they are run in the given order
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// validate checks the options for invalid combinations
+func (o *serverOptions) validate() error {
+	if o.keepalive != nil {
+		if err := o.keepalive.validate(); err != nil {
+			return err
+		}
+	}
+	if err := o.httpTimeouts.validate(); err != nil {
+		return err
+	}
+	if o.cors != nil {
+		return o.cors.validate()
+	}
+	return nil
+}
+
+func newServerOptions(options []ServerOption) *serverOptions {
+	o := &serverOptions{}
+	for _, option := range options {
+		if option != nil {
+			option(o)
+		}
+	}
+	return o
+}
+
+// grpcOptions returns the options to create the grpc server with.
+// Request IDs are assigned before any other interceptor runs, so all of them can log them.
+func (o *serverOptions) grpcOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{
+			RequestIDUnaryServerInterceptor()}, o.unaryInterceptors...)...),
+		grpc.ChainStreamInterceptor(append([]grpc.StreamServerInterceptor{
+			RequestIDStreamServerInterceptor()}, o.streamInterceptors...)...),
+	}
+	if o.keepalive != nil {
+		opts = append(opts, o.keepalive.grpcOptions()...)
+	}
+	return opts
+}
+
+// listen opens the listener described by the options for the given port.
+// A port of 0 lets the operating system pick an ephemeral port.
+func (o *serverOptions) listen(port int) (net.Listener, error) {
+	switch {
+	case o.listener != nil:
+		return o.listener, nil
+	case strings.HasPrefix(o.address, unixScheme):
+		return listenUnix(strings.TrimPrefix(o.address, unixScheme), o.socketPerms)
+	case o.address != "":
+		return net.Listen("tcp", o.address)
+	}
+	return net.Listen("tcp", net.JoinHostPort(o.host, strconv.Itoa(port)))
+}
+
+// listenUnix creates a unix domain socket at path. A socket file left behind
+// by a crashed process is removed, a socket still in use is left untouched.
+func listenUnix(path string, perms os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("GRPC server: Empty unix socket path")
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	return listenUnixSocket(path, perms)
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("GRPC server: %s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("GRPC server: Socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
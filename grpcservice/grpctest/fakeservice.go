@@ -0,0 +1,114 @@
+package grpctest
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// UnaryHandler answers a unary call of a fake service
+type UnaryHandler func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error)
+
+// StreamHandler answers a streaming call of a fake service,
+// messages sent and received on the stream are of type *structpb.Struct
+type StreamHandler func(stream grpc.ServerStream) error
+
+// FakeService is a grpc service without generated code.
+// Its methods exchange structpb.Struct messages,
+// which allows handlers and interceptors to be tested without compiling protos.
+type FakeService struct {
+	// Name is the fully qualified service name, e.g. "test.Echo"
+	Name    string
+	Unary   map[string]UnaryHandler
+	Streams map[string]StreamHandler
+}
+
+// Register adds the fake service to the grpc server, pass it as register function to New
+func (f *FakeService) Register(server *grpc.Server) {
+	desc := &grpc.ServiceDesc{
+		ServiceName: f.Name,
+		HandlerType: (*interface{})(nil),
+	}
+	for name, handler := range f.Unary {
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler:    unaryHandler(f.Name+"/"+name, handler),
+		})
+	}
+	for name, handler := range f.Streams {
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    name,
+			Handler:       streamHandler(handler),
+			ServerStreams: true,
+			ClientStreams: true,
+		})
+	}
+	server.RegisterService(desc, f)
+}
+
+// Register returns a register function adding all given fake services
+func Register(services ...*FakeService) func(*grpc.Server) {
+	return func(server *grpc.Server) {
+		for _, service := range services {
+			service.Register(server)
+		}
+	}
+}
+
+func unaryHandler(fullMethod string, handler UnaryHandler) grpc.MethodHandler {
+	return func(_ interface{}, ctx context.Context, decode func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		request := new(structpb.Struct)
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return handler(ctx, request)
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: "/" + fullMethod}
+		return interceptor(ctx, request, info,
+			func(ctx context.Context, request interface{}) (interface{}, error) {
+				return handler(ctx, request.(*structpb.Struct))
+			})
+	}
+}
+
+func streamHandler(handler StreamHandler) grpc.StreamHandler {
+	return func(_ interface{}, stream grpc.ServerStream) error {
+		return handler(stream)
+	}
+}
+
+// Invoke calls the unary method of a fake service on the given connection
+func Invoke(ctx context.Context, conn grpc.ClientConnInterface, service, method string,
+	request *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	response := new(structpb.Struct)
+	if request == nil {
+		request = new(structpb.Struct)
+	}
+	err := conn.Invoke(ctx, "/"+service+"/"+method, request, response, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// NewStream opens a bidirectional stream to the method of a fake service on the given connection
+func NewStream(ctx context.Context, conn grpc.ClientConnInterface, service, method string,
+	opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	desc := &grpc.StreamDesc{StreamName: method, ServerStreams: true, ClientStreams: true}
+	return conn.NewStream(ctx, desc, "/"+service+"/"+method, opts...)
+}
+
+// Invoke calls the unary method of a fake service using the default harness client
+func (h *Harness) Invoke(ctx context.Context, service, method string,
+	request *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return Invoke(ctx, h.Connection(), service, method, request, opts...)
+}
+
+// NewStream opens a stream to the method of a fake service using the default harness client
+func (h *Harness) NewStream(ctx context.Context, service, method string,
+	opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return NewStream(ctx, h.Connection(), service, method, opts...)
+}
@@ -0,0 +1,93 @@
+package grpctest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bufferSize is the size of the in-memory connection buffers
+const bufferSize = 1024 * 1024
+
+// serveTimeout is the time the server gets to start serving
+const serveTimeout = 5 * time.Second
+
+// Harness bundles a GRPCServer served on an in-memory listener with a client connected to it
+type Harness struct {
+	Server   *grpcservice.GRPCServer
+	Client   *grpcservice.GRPCClient
+	listener *bufconn.Listener
+	tb       testing.TB
+}
+
+// New starts a GRPCServer on an in-memory listener and returns a harness with a connected client.
+// The register function is called before serving to add services to the grpc server.
+// Server and client are stopped automatically once the test finishes.
+func New(tb testing.TB, register func(*grpc.Server), options ...grpcservice.ServerOption) *Harness {
+	tb.Helper()
+
+	listener := bufconn.Listen(bufferSize)
+	options = append(options, grpcservice.WithListener(listener))
+	grpcServer := grpcservice.NewGRPCServer(false, "", "", 0, options...)
+	if grpcServer == nil {
+		tb.Fatal("GRPC test harness: Failed to create server")
+	}
+	if register != nil {
+		register(grpcServer.GetInstance())
+	}
+
+	go grpcServer.Serve()
+	deadline := time.Now().Add(serveTimeout)
+	for !grpcServer.IsRunning() {
+		if time.Now().After(deadline) {
+			tb.Fatal("GRPC test harness: Server did not start serving")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tb.Cleanup(func() {
+		if err := grpcServer.Stop(); err != nil {
+			tb.Logf("GRPC test harness: Stopping server failed: %v", err)
+		}
+	})
+
+	harness := &Harness{Server: grpcServer, listener: listener, tb: tb}
+	harness.Client = harness.Connect(&grpcservice.ConnectionInfo{})
+	return harness
+}
+
+// Connect returns an additional client connected to the harness server.
+// IP, Port and Dialer of the given info are overwritten, everything else is used as is.
+// The client is closed automatically once the test finishes.
+func (h *Harness) Connect(info *grpcservice.ConnectionInfo) *grpcservice.GRPCClient {
+	h.tb.Helper()
+
+	connectionInfo := *info
+	connectionInfo.IP = "bufconn"
+	connectionInfo.Port = "0"
+	connectionInfo.SocketPath = ""
+	connectionInfo.Dialer = func(ctx context.Context, _ string) (net.Conn, error) {
+		return h.listener.DialContext(ctx)
+	}
+	if connectionInfo.TimeoutInMilliSecs == 0 {
+		connectionInfo.TimeoutInMilliSecs = 5000
+	}
+
+	client := new(grpcservice.GRPCClient)
+	if err := client.Connect(&connectionInfo); err != nil {
+		h.tb.Fatalf("GRPC test harness: Failed to connect client: %v", err)
+	}
+	h.tb.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+// Connection returns the connection of the default client
+func (h *Harness) Connection() *grpc.ClientConn {
+	return h.Client.GetConnection()
+}
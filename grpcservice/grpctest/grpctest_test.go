@@ -0,0 +1,110 @@
+package grpctest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var echo = &grpctest.FakeService{
+	Name: "test.Echo",
+	Unary: map[string]grpctest.UnaryHandler{
+		"Echo": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+			return request, nil
+		},
+		"Fail": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+			return nil, status.Error(codes.InvalidArgument, "failed on purpose")
+		},
+	},
+	Streams: map[string]grpctest.StreamHandler{
+		"EchoStream": func(stream grpc.ServerStream) error {
+			for {
+				message := new(structpb.Struct)
+				if err := stream.RecvMsg(message); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.SendMsg(message); err != nil {
+					return err
+				}
+			}
+		},
+	},
+}
+
+func TestSuiteHarness(t *testing.T) {
+	t.Run("UnaryCallSucceeds", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, echo.Register)
+		request, _ := structpb.NewStruct(map[string]interface{}{"message": "hello"})
+
+		// Exercise + Verify
+		response, err := harness.Invoke(context.Background(), "test.Echo", "Echo", request)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, response.Fields["message"].GetStringValue(), "hello")
+	})
+
+	t.Run("UnaryCallReturnsHandlerError", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, echo.Register)
+
+		// Exercise + Verify
+		_, err := harness.Invoke(context.Background(), "test.Echo", "Fail", nil)
+		test.AssertThat(t, status.Code(err), codes.InvalidArgument)
+	})
+
+	t.Run("UnknownMethodFails", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, echo.Register)
+
+		// Exercise + Verify
+		_, err := harness.Invoke(context.Background(), "test.Echo", "Unknown", nil)
+		test.AssertThat(t, status.Code(err), codes.Unimplemented)
+	})
+
+	t.Run("StreamingCallSucceeds", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, grpctest.Register(echo))
+		request, _ := structpb.NewStruct(map[string]interface{}{"count": 1})
+
+		// Exercise + Verify
+		stream, err := harness.NewStream(context.Background(), "test.Echo", "EchoStream")
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, stream.SendMsg(request), nil)
+		test.AssertThat(t, stream.CloseSend(), nil)
+
+		response := new(structpb.Struct)
+		test.AssertThat(t, stream.RecvMsg(response), nil)
+		test.AssertThat(t, response.Fields["count"].GetNumberValue(), float64(1))
+		test.AssertThat(t, stream.RecvMsg(response), io.EOF)
+	})
+
+	t.Run("AdditionalClientsCanConnect", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, echo.Register)
+
+		// Exercise + Verify
+		client := harness.Connect(&grpcservice.ConnectionInfo{})
+		_, err := grpctest.Invoke(context.Background(), client.GetConnection(),
+			"test.Echo", "Echo", nil)
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("ServerIsStoppedOnCleanup", func(t *testing.T) {
+		var harness *grpctest.Harness
+		t.Run("Inner", func(t *testing.T) {
+			harness = grpctest.New(t, echo.Register)
+			test.AssertThat(t, harness.Server.IsRunning(), true)
+		})
+		test.AssertThat(t, harness.Server.IsRunning(), false)
+	})
+}
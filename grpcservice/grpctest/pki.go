@@ -0,0 +1,213 @@
+package grpctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// CA is an ephemeral certificate authority issuing certificates for tests
+type CA struct {
+	Certificate *x509.Certificate
+	CertPEM     []byte
+	key         crypto.Signer
+}
+
+// KeyPair is a certificate issued by a CA together with its private key
+type KeyPair struct {
+	Certificate *x509.Certificate
+	CertPEM     []byte
+	KeyPEM      []byte
+}
+
+// CertificateRequest describes a certificate to issue.
+// Unset validity defaults to one hour starting a minute ago.
+type CertificateRequest struct {
+	CommonName string
+	// Hosts are added as IP address or DNS name SANs
+	Hosts []string
+	// URIs are added as URI SANs, e.g. SPIFFE IDs like spiffe://example.org/service
+	URIs      []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	Server    bool
+	Client    bool
+}
+
+// NewCA creates a self signed certificate authority with the given common name
+func NewCA(tb testing.TB, commonName string) *CA {
+	tb.Helper()
+
+	key := newKey(tb)
+	template := &x509.Certificate{
+		SerialNumber:          newSerial(tb),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		tb.Fatalf("Test PKI: Creating CA certificate failed: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("Test PKI: Parsing CA certificate failed: %v", err)
+	}
+	return &CA{
+		Certificate: certificate,
+		CertPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:         key,
+	}
+}
+
+// Issue creates a certificate signed by the CA
+func (ca *CA) Issue(tb testing.TB, request CertificateRequest) *KeyPair {
+	tb.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: newSerial(tb),
+		Subject:      pkix.Name{CommonName: request.CommonName},
+		NotBefore:    request.NotBefore,
+		NotAfter:     request.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Minute)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = template.NotBefore.Add(time.Hour)
+	}
+	for _, host := range request.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	for _, uri := range request.URIs {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			tb.Fatalf("Test PKI: Invalid URI SAN %q: %v", uri, err)
+		}
+		template.URIs = append(template.URIs, parsed)
+	}
+	if request.Server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if request.Client {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+
+	key := newKey(tb)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, key.Public(), ca.key)
+	if err != nil {
+		tb.Fatalf("Test PKI: Creating certificate failed: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("Test PKI: Parsing certificate failed: %v", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		tb.Fatalf("Test PKI: Marshalling key failed: %v", err)
+	}
+	return &KeyPair{
+		Certificate: certificate,
+		CertPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:      pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+// ServerCert issues a server certificate valid for the given hosts
+func (ca *CA) ServerCert(tb testing.TB, hosts ...string) *KeyPair {
+	tb.Helper()
+	commonName := "server"
+	if len(hosts) > 0 {
+		commonName = hosts[0]
+	}
+	return ca.Issue(tb, CertificateRequest{CommonName: commonName, Hosts: hosts, Server: true})
+}
+
+// ClientCert issues a client certificate for the given common name and URI SANs
+func (ca *CA) ClientCert(tb testing.TB, commonName string, uris ...string) *KeyPair {
+	tb.Helper()
+	return ca.Issue(tb, CertificateRequest{CommonName: commonName, URIs: uris, Client: true})
+}
+
+// CertPool returns a pool containing the CA certificate
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	return pool
+}
+
+// File writes the CA certificate to a temporary directory and returns its path
+func (ca *CA) File(tb testing.TB) string {
+	tb.Helper()
+	caFile := filepath.Join(tb.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, ca.CertPEM, 0600); err != nil {
+		tb.Fatalf("Test PKI: Writing CA file failed: %v", err)
+	}
+	return caFile
+}
+
+// TLSCertificate returns the key pair for use within a tls.Config
+func (kp *KeyPair) TLSCertificate(tb testing.TB) tls.Certificate {
+	tb.Helper()
+	certificate, err := tls.X509KeyPair(kp.CertPEM, kp.KeyPEM)
+	if err != nil {
+		tb.Fatalf("Test PKI: Loading key pair failed: %v", err)
+	}
+	return certificate
+}
+
+// Files writes certificate and key to a temporary directory and returns their paths
+func (kp *KeyPair) Files(tb testing.TB) (certFile string, keyFile string) {
+	tb.Helper()
+	dir := tb.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := kp.WriteFiles(certFile, keyFile); err != nil {
+		tb.Fatalf("Test PKI: Writing key pair failed: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// WriteFiles writes certificate and key to the given paths, e.g. to replace files being watched
+func (kp *KeyPair) WriteFiles(certFile, keyFile string) error {
+	if err := ioutil.WriteFile(certFile, kp.CertPEM, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, kp.KeyPEM, 0600)
+}
+
+func newKey(tb testing.TB) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("Test PKI: Generating key failed: %v", err)
+	}
+	return key
+}
+
+func newSerial(tb testing.TB) *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		tb.Fatalf("Test PKI: Generating serial number failed: %v", err)
+	}
+	return serial
+}
@@ -0,0 +1,63 @@
+package grpctest_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+func TestSuitePKI(t *testing.T) {
+	ca := grpctest.NewCA(t, "Test CA")
+
+	t.Run("ServerCertVerifiesForHosts", func(t *testing.T) {
+		serverCert := ca.ServerCert(t, "localhost", "127.0.0.1")
+		test.AssertThat(t, serverCert.Certificate.DNSNames[0], "localhost")
+		test.AssertThat(t, len(serverCert.Certificate.IPAddresses), 1)
+
+		_, err := serverCert.Certificate.Verify(x509.VerifyOptions{
+			DNSName: "localhost", Roots: ca.CertPool()})
+		test.AssertThat(t, err, nil)
+		_, err = serverCert.Certificate.Verify(x509.VerifyOptions{
+			DNSName: "example.com", Roots: ca.CertPool()})
+		test.AssertThat(t, err, nil, "not")
+	})
+
+	t.Run("ClientCertContainsURIs", func(t *testing.T) {
+		clientCert := ca.ClientCert(t, "client", "spiffe://example.org/client")
+		test.AssertThat(t, clientCert.Certificate.Subject.CommonName, "client")
+		test.AssertThat(t, clientCert.Certificate.URIs[0].String(), "spiffe://example.org/client")
+
+		_, err := clientCert.Certificate.Verify(x509.VerifyOptions{Roots: ca.CertPool(),
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("ExpiredCertFailsVerification", func(t *testing.T) {
+		expired := ca.Issue(t, grpctest.CertificateRequest{CommonName: "expired",
+			Hosts: []string{"localhost"}, Server: true,
+			NotBefore: time.Now().Add(-2 * time.Hour), NotAfter: time.Now().Add(-time.Hour)})
+
+		_, err := expired.Certificate.Verify(x509.VerifyOptions{
+			DNSName: "localhost", Roots: ca.CertPool()})
+		test.AssertThat(t, err, "expired", "contains")
+	})
+
+	t.Run("CertOfOtherCAFailsVerification", func(t *testing.T) {
+		other := grpctest.NewCA(t, "Other CA").ServerCert(t, "localhost")
+
+		_, err := other.Certificate.Verify(x509.VerifyOptions{
+			DNSName: "localhost", Roots: ca.CertPool()})
+		test.AssertThat(t, err, "unknown authority", "contains")
+	})
+
+	t.Run("FilesCanBeLoaded", func(t *testing.T) {
+		certFile, keyFile := ca.ServerCert(t, "localhost").Files(t)
+		_, err := tls.LoadX509KeyPair(certFile, keyFile)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, ca.File(t), "ca.pem", "contains")
+	})
+}
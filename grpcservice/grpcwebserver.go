@@ -1,124 +1,194 @@
 package grpcservice
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"sync"
 
-	"github.com/gorilla/handlers"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"google.golang.org/grpc"
 )
 
 // The GRPCWebServer is a struct defining all the contents needed to setup a grpc server.
 type GRPCWebServer struct {
-	innerServer *grpc.Server
-	server      *grpcweb.WrappedGrpcServer
-	isRunning   bool
-	useTLS      bool
-	certFile    string
-	keyFile     string
-	port        int
+	innerServer  *grpc.Server
+	server       *grpcweb.WrappedGrpcServer
+	httpServer   *http.Server
+	listener     net.Listener
+	certificates *CertificateProvider
+	useTLS       bool
+	certFile     string
+	keyFile      string
+
+	mutex     sync.Mutex
+	isRunning bool
 }
 
-// NewGRPCWebServer initializes a server struct offering a GRPCWeb Web service
-func NewGRPCWebServer(useTLS bool, certFile string, keyFile string, port int) *GRPCWebServer {
-	server := grpc.NewServer()
+// NewGRPCWebServer initializes a server struct offering a GRPCWeb Web service.
+// A port of 0 binds to an ephemeral port, see Addr for the actual address.
+func NewGRPCWebServer(useTLS bool, certFile string, keyFile string, port int,
+	options ...ServerOption) *GRPCWebServer {
+	serverOptions := newServerOptions(options)
+	if err := serverOptions.validate(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	if err := serverOptions.validateHTTP(); err != nil {
+		log.Println(err)
+		return nil
+	}
+	listener, err := serverOptions.listen(port)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	server := grpc.NewServer(serverOptions.grpcOptions()...)
 	webServer := grpcweb.WrapServer(server)
-	log.Print("GRPC Web server: Listening on port ", port)
+	log.Print("GRPC Web server: Listening on ", listener.Addr())
 	if useTLS {
 		log.Print("GRPC Web server: Preparing server (with TLS)")
 	} else {
 		log.Print("GRPC Web server: Preparing server (without TLS)")
 	}
+	handler := serverOptions.wrapHTTPHandler(
+		grpcWebHandler(webServer, serverOptions.cors, serverOptions.fallbackHandler()))
 	return &GRPCWebServer{
-		server:      webServer,
-		innerServer: server,
-		useTLS:      useTLS,
-		certFile:    certFile,
-		keyFile:     keyFile,
-		port:        port,
+		server:       webServer,
+		innerServer:  server,
+		httpServer:   serverOptions.newHTTPServer(handler, useTLS),
+		listener:     listener,
+		certificates: serverOptions.certificates,
+		useTLS:       useTLS,
+		certFile:     certFile,
+		keyFile:      keyFile,
 	}
 }
 
 // Serve registers the server as grpc server
 func (grpcserver *GRPCWebServer) Serve() error {
 
-	if grpcserver == nil || grpcserver.server == nil {
+	if grpcserver == nil {
+		return fmt.Errorf("GRPCWeb server: Is not initialized")
+	}
+	grpcserver.mutex.Lock()
+	if grpcserver.server == nil {
+		grpcserver.mutex.Unlock()
 		return fmt.Errorf("GRPCWeb server: Is not initialized")
 	}
-	if grpcserver.IsRunning() {
+	if grpcserver.isRunning {
+		grpcserver.mutex.Unlock()
 		return fmt.Errorf("GRPCWeb server: Instance is already running")
 	}
-
 	grpcserver.isRunning = true
-	grpcHandler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-		// Allow origins
-		if origin := req.Header.Get("Origin"); origin != "" {
-			resp.Header().Set("Access-Control-Allow-Origin", origin)
-			resp.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			resp.Header().Set("Access-Control-Allow-Headers",
-				"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, x-grpc-web")
-		}
-		// Stop here if its Preflighted OPTIONS request
-		if req.Method == "OPTIONS" {
-			return
-		}
+	grpcserver.mutex.Unlock()
 
-		if grpcserver.server.IsGrpcWebRequest(req) {
-			// Answer with GRPC
-			grpcserver.server.ServeHTTP(resp, req)
-		} else {
-			// Fall back to other servers
-			http.DefaultServeMux.ServeHTTP(resp, req)
-		}
-	})
 	var err error
-	handler := handlers.LoggingHandler(os.Stdout, grpcHandler)
-
 	if !grpcserver.useTLS {
-		err = http.ListenAndServe(fmt.Sprintf(":%v", grpcserver.port), handler)
+		err = grpcserver.httpServer.Serve(grpcserver.listener)
+	} else if grpcserver.certificates != nil {
+		err = grpcserver.httpServer.ServeTLS(grpcserver.listener, "", "")
 	} else {
-		err = http.ListenAndServeTLS(fmt.Sprintf(":%v", grpcserver.port),
-			grpcserver.certFile, grpcserver.keyFile, handler)
+		err = grpcserver.httpServer.ServeTLS(grpcserver.listener,
+			grpcserver.certFile, grpcserver.keyFile)
+	}
+	if err == http.ErrServerClosed {
+		return nil
 	}
 	return err
 }
 
-// Stop the grpc server
+// grpcWebHandler answers grpc-web requests and passes everything else to the fallback.
+// Cross-origin requests are allowed according to the CORS policy.
+func grpcWebHandler(server *grpcweb.WrappedGrpcServer, cors *CORSPolicy, fallback http.Handler) http.Handler {
+	return corsHandler(cors, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if server.IsGrpcWebRequest(req) {
+			// Answer with GRPC
+			server.ServeHTTP(resp, req)
+		} else {
+			// Fall back to other handlers
+			fallback.ServeHTTP(resp, req)
+		}
+	}))
+}
+
+// Stop the grpc server, closing the listener and all connections
 func (grpcserver *GRPCWebServer) Stop() error {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	if grpcserver.server == nil {
 		return fmt.Errorf("GRPCWeb server: Is not initialized")
 	}
 
-	if !grpcserver.IsRunning() {
+	if !grpcserver.isRunning {
 		return fmt.Errorf("GRPCWeb server: Is not running")
 	}
 
 	grpcserver.innerServer.Stop()
+	err := grpcserver.httpServer.Close()
 	grpcserver.server = nil
 	grpcserver.isRunning = false
 
-	return nil
+	return err
+}
+
+// Shutdown stops accepting connections and waits for running grpc-web and HTTP requests
+// until the context ends. Remaining connections are closed afterwards and the error of
+// the context is returned.
+func (grpcserver *GRPCWebServer) Shutdown(ctx context.Context) error {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
+	if grpcserver.server == nil {
+		return fmt.Errorf("GRPCWeb server: Is not initialized")
+	}
+
+	if !grpcserver.isRunning {
+		return fmt.Errorf("GRPCWeb server: Is not running")
+	}
+
+	err := grpcserver.httpServer.Shutdown(ctx)
+	grpcserver.innerServer.Stop()
+	if err != nil {
+		grpcserver.httpServer.Close()
+	}
+	grpcserver.server = nil
+	grpcserver.isRunning = false
+
+	return err
 }
 
 // IsRunning indicates if the server started listening properly
-func (grpcserver GRPCWebServer) IsRunning() bool {
+func (grpcserver *GRPCWebServer) IsRunning() bool {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	return grpcserver.isRunning
 }
 
+// Addr returns the address the server is bound to or nil if it is not initialized
+func (grpcserver *GRPCWebServer) Addr() net.Addr {
+	if grpcserver.listener == nil {
+		return nil
+	}
+	return grpcserver.listener.Addr()
+}
+
 // IsInitialized indicates if the server was initialized properly
-func (grpcserver GRPCWebServer) IsInitialized() bool {
+func (grpcserver *GRPCWebServer) IsInitialized() bool {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	return (grpcserver.server != nil)
 }
 
 // GetInstance returns a pointer to server instance
-func (grpcserver GRPCWebServer) GetInstance() *grpcweb.WrappedGrpcServer {
+func (grpcserver *GRPCWebServer) GetInstance() *grpcweb.WrappedGrpcServer {
+	grpcserver.mutex.Lock()
+	defer grpcserver.mutex.Unlock()
 	return grpcserver.server
 }
 
 // GetInnerInstance returns a pointer to server instance
-func (grpcserver GRPCWebServer) GetInnerInstance() *grpc.Server {
+func (grpcserver *GRPCWebServer) GetInnerInstance() *grpc.Server {
 	return grpcserver.innerServer
 }
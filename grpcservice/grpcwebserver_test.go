@@ -1,14 +1,56 @@
 package grpcservice_test
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"testing"
 	"time"
 
 	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
 	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// waitUntilServing waits up to a second for the server to start serving
+func waitUntilServing(tempServer *grpcservice.GRPCWebServer) {
+	deadline := time.Now().Add(time.Second)
+	for !tempServer.IsRunning() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startWebServer starts a grpc-web server offering a slow variant of the measured service,
+// calls take the given time unless their context ends first
+func startWebServer(t *testing.T, delay time.Duration,
+	options ...grpcservice.ServerOption) *grpcservice.GRPCWebServer {
+	tempServer := grpcservice.NewGRPCWebServer(false, "", "", 0,
+		append([]grpcservice.ServerOption{grpcservice.WithHost("127.0.0.1")}, options...)...)
+	service := &grpctest.FakeService{
+		Name: "test.Measured",
+		Unary: map[string]grpctest.UnaryHandler{
+			"Call": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+				return request, nil
+			},
+		},
+	}
+	service.Register(tempServer.GetInnerInstance())
+	go tempServer.Serve()
+	waitUntilServing(tempServer)
+	t.Cleanup(func() {
+		tempServer.Stop()
+	})
+	return tempServer
+}
+
 /*
  * GRPCWeb SERVICE unit test suite
  */
@@ -93,4 +135,133 @@ func TestSuiteGRPCWebServer(t *testing.T) {
 		err := tempServer.Stop()
 		test.AssertThat(t, err, "GRPCWeb server: Is not running", "streq")
 	})
+	t.Run("EphemeralPortIsExposedByAddr", func(t *testing.T) {
+		// SetUp
+		tempServer := grpcservice.NewGRPCWebServer(false, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"))
+
+		// Exercise + Verify
+		addr, ok := tempServer.Addr().(*net.TCPAddr)
+		test.AssertThat(t, ok, true)
+		test.AssertThat(t, addr.IP.String(), "127.0.0.1")
+		test.AssertThat(t, addr.Port, 0, "not")
+
+		// TearDown
+		go tempServer.Serve()
+		waitUntilServing(tempServer)
+		test.AssertThat(t, tempServer.Stop(), nil)
+	})
+	t.Run("ServingWithTLSSucceeds", func(t *testing.T) {
+		// SetUp
+		ca := grpctest.NewCA(t, "Test CA")
+		certFile, keyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+		tempServer := grpcservice.NewGRPCWebServer(true, certFile, keyFile, 0,
+			grpcservice.WithHost("127.0.0.1"))
+		go tempServer.Serve()
+
+		// Exercise + Verify
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.CertPool()}}}
+		var response *http.Response
+		var err error
+		for i := 0; i < 100; i++ {
+			if response, err = client.Get("https://" + tempServer.Addr().String()); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		test.AssertThat(t, err, nil)
+		response.Body.Close()
+		test.AssertThat(t, response.TLS.PeerCertificates[0].Subject.CommonName, "127.0.0.1")
+
+		// TearDown
+		client.CloseIdleConnections()
+		err = tempServer.Stop()
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("StopReleasesThePort", func(t *testing.T) {
+		// SetUp
+		tempServer := startWebServer(t, 0)
+		url := "http://" + tempServer.Addr().String()
+		test.AssertThat(t, callGRPCWeb(t, http.DefaultClient, url, "web"), "web")
+
+		// Exercise
+		err := tempServer.Stop()
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		listener, err := net.Listen("tcp", tempServer.Addr().String())
+		test.AssertThat(t, err, nil)
+		listener.Close()
+	})
+
+	t.Run("ShutdownDrainsRunningRequests", func(t *testing.T) {
+		// SetUp
+		tempServer := startWebServer(t, 200*time.Millisecond)
+		url := "http://" + tempServer.Addr().String()
+		shutdown := make(chan error, 1)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			shutdown <- tempServer.Shutdown(context.Background())
+		}()
+
+		// Exercise
+		value := callGRPCWeb(t, http.DefaultClient, url, "draining")
+
+		// Verify
+		test.AssertThat(t, value, "draining")
+		test.AssertThat(t, <-shutdown, nil)
+		test.AssertThat(t, tempServer.IsRunning(), false)
+		_, err := net.Dial("tcp", tempServer.Addr().String())
+		test.AssertThat(t, err, nil, "not")
+	})
+
+	t.Run("ShutdownClosesConnectionsAfterDeadline", func(t *testing.T) {
+		// SetUp
+		tempServer := startWebServer(t, time.Minute)
+		url := "http://" + tempServer.Addr().String()
+		request := grpcWebRequest(t, url, "stuck")
+		go http.DefaultClient.Do(request)
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// Exercise
+		start := time.Now()
+		err := tempServer.Shutdown(ctx)
+
+		// Verify
+		test.AssertThat(t, err, context.DeadlineExceeded)
+		test.AssertThat(t, time.Since(start) < time.Second, true)
+		test.AssertThat(t, tempServer.Shutdown(context.Background()),
+			"GRPCWeb server: Is not initialized", "streq")
+	})
+
+	t.Run("SlowClientsAreDisconnectedByTimeouts", func(t *testing.T) {
+		// SetUp
+		tempServer := startWebServer(t, 0, grpcservice.WithHTTPTimeouts(grpcservice.HTTPTimeouts{
+			ReadHeader: 50 * time.Millisecond, Idle: time.Second}))
+		conn, err := net.Dial("tcp", tempServer.Addr().String())
+		test.AssertThat(t, err, nil)
+		defer conn.Close()
+
+		// Exercise
+		_, err = conn.Write([]byte("POST /test.Measured/Call HTTP/1.1\r\n"))
+		test.AssertThat(t, err, nil)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = ioutil.ReadAll(conn)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("NegativeHTTPTimeoutsFail", func(t *testing.T) {
+		// Exercise
+		tempServer := grpcservice.NewGRPCWebServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"),
+			grpcservice.WithHTTPTimeouts(grpcservice.HTTPTimeouts{Write: -time.Second}))
+
+		// Verify
+		test.AssertThat(t, tempServer == nil, true)
+	})
 }
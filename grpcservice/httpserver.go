@@ -0,0 +1,133 @@
+package grpcservice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/quaponatech/golang-extensions/server"
+)
+
+// HTTPTimeouts limits the time spent on HTTP connections of GRPCWebServer and GRPCMuxServer.
+// A zero value disables the timeout, which is the default for all of them. Native grpc calls
+// of the GRPCMuxServer are exempt from Read and Write, deadlines of the calls limit them instead.
+type HTTPTimeouts struct {
+	// ReadHeader is the time allowed to read the request headers
+	ReadHeader time.Duration
+	// Read is the time allowed to read the whole request including the body
+	Read time.Duration
+	// Write is the time allowed to write the response, it ends streams running longer
+	Write time.Duration
+	// Idle is the time a keep-alive connection waits for the next request
+	Idle time.Duration
+}
+
+// WithHTTPTimeouts sets the timeouts of the HTTP server serving grpc-web and HTTP requests
+func WithHTTPTimeouts(timeouts HTTPTimeouts) ServerOption {
+	return func(o *serverOptions) {
+		o.httpTimeouts = timeouts
+	}
+}
+
+// validate checks the timeouts for negative values
+func (t HTTPTimeouts) validate() error {
+	for name, value := range map[string]time.Duration{
+		"read header": t.ReadHeader,
+		"read":        t.Read,
+		"write":       t.Write,
+		"idle":        t.Idle,
+	} {
+		if value < 0 {
+			return fmt.Errorf("GRPC server: Negative HTTP %s timeout %v", name, value)
+		}
+	}
+	return nil
+}
+
+// validateHTTP rejects options of the grpc transport, which is not used when serving over HTTP
+func (o *serverOptions) validateHTTP() error {
+	if o.keepalive != nil {
+		return fmt.Errorf("GRPC server: Keepalive is not supported when serving over HTTP, use HTTP timeouts")
+	}
+	return nil
+}
+
+// HTTPMiddleware wraps the handler of every HTTP request
+type HTTPMiddleware func(http.Handler) http.Handler
+
+// WithHTTPHandler serves requests which are neither grpc-web nor native grpc with the
+// handler instead of http.DefaultServeMux, e.g. static assets, health checks or metrics
+func WithHTTPHandler(handler http.Handler) ServerOption {
+	return func(o *serverOptions) {
+		o.httpHandler = handler
+	}
+}
+
+// WithHTTPMiddleware wraps all HTTP requests including grpc-web, native grpc of the
+// GRPCMuxServer and CORS preflights, the middleware is run in the given order
+func WithHTTPMiddleware(middleware ...HTTPMiddleware) ServerOption {
+	return func(o *serverOptions) {
+		o.httpMiddleware = append(o.httpMiddleware, middleware...)
+	}
+}
+
+// WithAccessLog sends the access log of HTTP requests to the info channel of the logger
+// instead of stdout. A nil logger disables the access log. Lines are dropped while the
+// logger is busy, so a slow logger does not hold up requests.
+func WithAccessLog(logger *server.Logger) ServerOption {
+	return func(o *serverOptions) {
+		o.accessLog = &accessLogWriter{logger: logger}
+	}
+}
+
+// accessLogWriter passes each line of the access log on to the logger
+type accessLogWriter struct {
+	logger *server.Logger
+}
+
+// Write offers the lines to the logger without waiting, they are written whole by the logging handler
+func (w *accessLogWriter) Write(line []byte) (int, error) {
+	w.logger.TryInfof(context.Background(), "%s", strings.TrimRight(string(line), "\n"))
+	return len(line), nil
+}
+
+// fallbackHandler returns the handler of plain HTTP requests
+func (o *serverOptions) fallbackHandler() http.Handler {
+	if o.httpHandler != nil {
+		return o.httpHandler
+	}
+	return http.DefaultServeMux
+}
+
+// wrapHTTPHandler puts the middleware and the access log around the handler,
+// the access log comes first to record the responses of the middleware as well
+func (o *serverOptions) wrapHTTPHandler(handler http.Handler) http.Handler {
+	for i := len(o.httpMiddleware) - 1; i >= 0; i-- {
+		handler = o.httpMiddleware[i](handler)
+	}
+	var accessLog io.Writer = os.Stdout
+	if o.accessLog != nil {
+		accessLog = o.accessLog
+	}
+	return handlers.LoggingHandler(accessLog, handler)
+}
+
+// newHTTPServer creates the HTTP server owned by a grpc-web or mux server
+func (o *serverOptions) newHTTPServer(handler http.Handler, useTLS bool) *http.Server {
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: o.httpTimeouts.ReadHeader,
+		ReadTimeout:       o.httpTimeouts.Read,
+		WriteTimeout:      o.httpTimeouts.Write,
+		IdleTimeout:       o.httpTimeouts.Idle,
+	}
+	if useTLS && o.certificates != nil {
+		httpServer.TLSConfig = o.certificates.TLSConfig(false)
+	}
+	return httpServer
+}
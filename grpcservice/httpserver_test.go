@@ -0,0 +1,135 @@
+package grpcservice_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+// defaultMuxOnce registers the page only served by http.DefaultServeMux once
+var defaultMuxOnce sync.Once
+
+// appHandler serves a page at /app and nothing else
+func appHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, "app")
+	})
+	return mux
+}
+
+// chainMiddleware adds its name to the X-Chain header of the response
+func chainMiddleware(name string) grpcservice.HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Add("X-Chain", name)
+			next.ServeHTTP(resp, req)
+		})
+	}
+}
+
+// get fetches the path and returns status and body
+func get(t *testing.T, url string) (int, string) {
+	response, err := http.Get(url)
+	test.AssertThat(t, err, nil)
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	return response.StatusCode, string(body)
+}
+
+func TestSuiteHTTPServer(t *testing.T) {
+	t.Run("FallbackHandlerServesPlainRequests", func(t *testing.T) {
+		// SetUp
+		tempServer := startWebServer(t, 0, grpcservice.WithHTTPHandler(appHandler()))
+		url := "http://" + tempServer.Addr().String()
+		defaultMuxOnce.Do(func() {
+			http.HandleFunc("/default-mux-only", func(resp http.ResponseWriter, req *http.Request) {
+				io.WriteString(resp, "default")
+			})
+		})
+
+		// Exercise
+		appStatus, appBody := get(t, url+"/app")
+		defaultStatus, _ := get(t, url+"/default-mux-only")
+
+		// Verify
+		test.AssertThat(t, appStatus, http.StatusOK)
+		test.AssertThat(t, appBody, "app")
+		test.AssertThat(t, defaultStatus, http.StatusNotFound)
+		test.AssertThat(t, callGRPCWeb(t, http.DefaultClient, url, "web"), "web")
+	})
+
+	t.Run("MiddlewareWrapsAllRequestsInOrder", func(t *testing.T) {
+		// SetUp
+		tempServer := startMuxServer(t, false, "", "", grpcservice.WithHTTPHandler(appHandler()),
+			grpcservice.WithHTTPMiddleware(chainMiddleware("first"), chainMiddleware("second")),
+			grpcservice.WithHTTPMiddleware(chainMiddleware("third")))
+		url := "http://" + tempServer.Addr().String()
+
+		// Exercise
+		appResponse, err := http.Get(url + "/app")
+		test.AssertThat(t, err, nil)
+		appResponse.Body.Close()
+		webResponse, err := http.DefaultClient.Do(grpcWebRequest(t, url, "web"))
+		test.AssertThat(t, err, nil)
+		webResponse.Body.Close()
+
+		// Verify
+		test.AssertThat(t, strings.Join(appResponse.Header.Values("X-Chain"), ","), "first,second,third")
+		test.AssertThat(t, strings.Join(webResponse.Header.Values("X-Chain"), ","), "first,second,third")
+	})
+
+	t.Run("AccessLogIsSentToLogger", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string, 10)}
+		tempServer := startWebServer(t, 0, grpcservice.WithHTTPHandler(appHandler()),
+			grpcservice.WithAccessLog(logger))
+		url := "http://" + tempServer.Addr().String()
+
+		// Exercise
+		status, _ := get(t, url+"/app")
+
+		// Verify
+		test.AssertThat(t, status, http.StatusOK)
+		line := <-logger.LogChan
+		test.AssertThat(t, line, `"GET /app HTTP/1.1" 200 3`, "contains")
+		test.AssertThat(t, strings.HasSuffix(line, "\n"), false)
+	})
+
+	t.Run("BusyAccessLogDoesNotHoldUpRequests", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string)}
+		tempServer := startWebServer(t, 0, grpcservice.WithHTTPHandler(appHandler()),
+			grpcservice.WithAccessLog(logger))
+		url := "http://" + tempServer.Addr().String()
+		client := &http.Client{Timeout: time.Second}
+
+		// Exercise
+		response, err := client.Get(url + "/app")
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		response.Body.Close()
+		test.AssertThat(t, response.StatusCode, http.StatusOK)
+	})
+
+	t.Run("AccessLogOfNilLoggerIsDropped", func(t *testing.T) {
+		// SetUp
+		tempServer := startWebServer(t, 0, grpcservice.WithHTTPHandler(appHandler()),
+			grpcservice.WithAccessLog(nil))
+		url := "http://" + tempServer.Addr().String()
+
+		// Exercise + Verify
+		status, body := get(t, url+"/app")
+		test.AssertThat(t, status, http.StatusOK)
+		test.AssertThat(t, body, "app")
+	})
+}
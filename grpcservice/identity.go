@@ -0,0 +1,186 @@
+package grpcservice
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// spiffeScheme is the URI scheme of SPIFFE IDs
+const spiffeScheme = "spiffe"
+
+// Identity describes a caller authenticated by a verified mutual TLS client certificate
+type Identity struct {
+	Subject        pkix.Name
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// SPIFFEID is the first spiffe:// URI SAN, if any
+	SPIFFEID    string
+	Certificate *x509.Certificate
+}
+
+// identityKey is the context key of the caller identity
+type identityKey struct{}
+
+// NewIdentity extracts the identity from a client certificate
+func NewIdentity(certificate *x509.Certificate) *Identity {
+	identity := &Identity{
+		Subject:        certificate.Subject,
+		CommonName:     certificate.Subject.CommonName,
+		DNSNames:       certificate.DNSNames,
+		EmailAddresses: certificate.EmailAddresses,
+		IPAddresses:    certificate.IPAddresses,
+		URIs:           certificate.URIs,
+		Certificate:    certificate,
+	}
+	for _, uri := range certificate.URIs {
+		if uri.Scheme == spiffeScheme {
+			identity.SPIFFEID = uri.String()
+			break
+		}
+	}
+	return identity
+}
+
+// Names returns everything the identity can be referred to by within an AuthorizationPolicy:
+// the common name, DNS names, email addresses, IP addresses and URIs
+func (i *Identity) Names() []string {
+	var names []string
+	if i.CommonName != "" {
+		names = append(names, i.CommonName)
+	}
+	names = append(names, i.DNSNames...)
+	names = append(names, i.EmailAddresses...)
+	for _, ip := range i.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range i.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// IdentityFromPeer extracts the identity of the calling peer from its verified client certificate
+func IdentityFromPeer(ctx context.Context) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("Identity: No peer found in context")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, errors.New("Identity: Peer is not connected with TLS")
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, errors.New("Identity: Peer has no verified client certificate")
+	}
+	return NewIdentity(chains[0][0]), nil
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the identity
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity put into the context by the identity interceptors
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// AuthorizationPolicy maps services or methods to the identities allowed to call them.
+// Keys of Rules are full method names like "/package.Service/Method",
+// "/package.Service/*" for all methods of a service or "*" for any method.
+// Values list identity names as returned by Identity.Names, "*" allows any verified identity.
+// The most specific matching rule applies.
+type AuthorizationPolicy struct {
+	Rules map[string][]string
+	// DefaultAllow lets verified identities call methods without a matching rule
+	DefaultAllow bool
+}
+
+// Authorize decides whether the identity may call the method
+func (p *AuthorizationPolicy) Authorize(fullMethod string, identity *Identity) bool {
+	if identity == nil {
+		return false
+	}
+	allowed, ok := p.rule(fullMethod)
+	if !ok {
+		return p.DefaultAllow
+	}
+	names := identity.Names()
+	for _, allowedName := range allowed {
+		if allowedName == "*" {
+			return true
+		}
+		for _, name := range names {
+			if name == allowedName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rule returns the most specific rule for the method
+func (p *AuthorizationPolicy) rule(fullMethod string) ([]string, bool) {
+	pattern, ok := mostSpecificPattern(fullMethod, func(pattern string) bool {
+		_, ok := p.Rules[pattern]
+		return ok
+	})
+	return p.Rules[pattern], ok
+}
+
+// authorize extracts the identity of the caller and checks it against the policy.
+// Without a policy callers lacking an identity are let through unchanged.
+func authorize(ctx context.Context, fullMethod string, policy *AuthorizationPolicy) (context.Context, error) {
+	identity, err := IdentityFromPeer(ctx)
+	if err != nil {
+		if policy == nil {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if policy != nil && !policy.Authorize(fullMethod, identity) {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"Identity: %s is not allowed to call %s", identity.CommonName, fullMethod)
+	}
+	return ContextWithIdentity(ctx, identity), nil
+}
+
+// IdentityUnaryInterceptor puts the identity of mutual TLS callers into the request context,
+// see IdentityFromContext. Calls are rejected if they violate the optional policy.
+func IdentityUnaryInterceptor(policy *AuthorizationPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, info.FullMethod, policy)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, request)
+	}
+}
+
+// IdentityStreamInterceptor is the streaming counterpart of IdentityUnaryInterceptor
+func IdentityStreamInterceptor(policy *AuthorizationPolicy) grpc.StreamServerInterceptor {
+	return func(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		ctx, err := authorize(stream.Context(), info.FullMethod, policy)
+		if err != nil {
+			return err
+		}
+		return handler(server, withContext(stream, ctx))
+	}
+}
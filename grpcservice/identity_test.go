@@ -0,0 +1,175 @@
+package grpcservice_test
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var whoami = &grpctest.FakeService{
+	Name: "test.Identity",
+	Unary: map[string]grpctest.UnaryHandler{
+		"Whoami": func(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+			identity, ok := grpcservice.IdentityFromContext(ctx)
+			if !ok {
+				return structpb.NewStruct(map[string]interface{}{})
+			}
+			return structpb.NewStruct(map[string]interface{}{
+				"commonName": identity.CommonName, "spiffeID": identity.SPIFFEID})
+		},
+		"Admin": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+			return request, nil
+		},
+	},
+}
+
+// connectMutual serves whoami with mutual TLS and connects a client presenting the given certificate
+func connectMutual(t *testing.T, ca *grpctest.CA, client *grpctest.KeyPair,
+	options ...grpcservice.ServerOption) *grpcservice.GRPCClient {
+	serverCertFile, serverKeyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+	options = append(options, grpcservice.WithHost("127.0.0.1"))
+	tempServer := grpcservice.NewMutualGRPCServer(true,
+		serverCertFile, serverKeyFile, ca.File(t), 0, options...)
+	whoami.Register(tempServer.GetInstance())
+	startServer(t, tempServer)
+	t.Cleanup(func() { tempServer.Stop() })
+
+	_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+	clientCertFile, clientKeyFile := client.Files(t)
+	tempClient := new(grpcservice.GRPCClient)
+	err := tempClient.ConnectMutual(&grpcservice.ConnectionInfo{UseTLS: true,
+		CertFile: clientCertFile, KeyFile: clientKeyFile, CaFile: ca.File(t),
+		IP: "127.0.0.1", Port: port, TimeoutInMilliSecs: 1000})
+	test.AssertThat(t, err, nil)
+	t.Cleanup(func() { tempClient.Close() })
+	return tempClient
+}
+
+func TestSuiteIdentity(t *testing.T) {
+	ca := grpctest.NewCA(t, "Test CA")
+	spiffeID, _ := url.Parse("spiffe://example.org/allowed")
+	allowed := &grpcservice.Identity{CommonName: "allowed", URIs: []*url.URL{spiffeID}}
+	other := &grpcservice.Identity{CommonName: "other", DNSNames: []string{"other.example.org"}}
+	policy := &grpcservice.AuthorizationPolicy{Rules: map[string][]string{
+		"/test.Identity/Admin": {"spiffe://example.org/allowed"},
+		"/test.Identity/*":     {"*"},
+	}}
+
+	t.Run("AuthorizeUsesMostSpecificRule", func(t *testing.T) {
+		test.AssertThat(t, policy.Authorize("/test.Identity/Admin", allowed), true)
+		test.AssertThat(t, policy.Authorize("/test.Identity/Admin", other), false)
+		test.AssertThat(t, policy.Authorize("/test.Identity/Whoami", other), true)
+	})
+
+	t.Run("AuthorizeFallsBackToDefault", func(t *testing.T) {
+		test.AssertThat(t, policy.Authorize("/test.Other/Method", allowed), false)
+		defaultPolicy := &grpcservice.AuthorizationPolicy{DefaultAllow: true}
+		test.AssertThat(t, defaultPolicy.Authorize("/test.Other/Method", allowed), true)
+		test.AssertThat(t, defaultPolicy.Authorize("/test.Other/Method", nil), false)
+	})
+
+	t.Run("AuthorizeMatchesDNSNames", func(t *testing.T) {
+		dnsPolicy := &grpcservice.AuthorizationPolicy{Rules: map[string][]string{
+			"*": {"other.example.org"}}}
+		test.AssertThat(t, dnsPolicy.Authorize("/test.Other/Method", other), true)
+		test.AssertThat(t, dnsPolicy.Authorize("/test.Other/Method", allowed), false)
+	})
+
+	t.Run("HandlerReceivesIdentityOfClientCertificate", func(t *testing.T) {
+		// SetUp
+		client := connectMutual(t, ca,
+			ca.ClientCert(t, "allowed", "spiffe://example.org/allowed"),
+			grpcservice.WithUnaryInterceptors(grpcservice.IdentityUnaryInterceptor(policy)))
+
+		// Exercise + Verify
+		response, err := grpctest.Invoke(context.Background(), client.GetConnection(),
+			"test.Identity", "Whoami", nil)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, response.Fields["commonName"].GetStringValue(), "allowed")
+		test.AssertThat(t, response.Fields["spiffeID"].GetStringValue(),
+			"spiffe://example.org/allowed")
+
+		_, err = grpctest.Invoke(context.Background(), client.GetConnection(),
+			"test.Identity", "Admin", nil)
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("ForbiddenIdentityIsRejected", func(t *testing.T) {
+		// SetUp
+		client := connectMutual(t, ca, ca.ClientCert(t, "other"),
+			grpcservice.WithUnaryInterceptors(grpcservice.IdentityUnaryInterceptor(policy)))
+
+		// Exercise + Verify
+		_, err := grpctest.Invoke(context.Background(), client.GetConnection(),
+			"test.Identity", "Admin", nil)
+		test.AssertThat(t, status.Code(err), codes.PermissionDenied)
+	})
+
+	t.Run("MissingIdentityIsUnauthenticated", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, whoami.Register,
+			grpcservice.WithUnaryInterceptors(grpcservice.IdentityUnaryInterceptor(policy)))
+
+		// Exercise + Verify
+		_, err := harness.Invoke(context.Background(), "test.Identity", "Whoami", nil)
+		test.AssertThat(t, status.Code(err), codes.Unauthenticated)
+	})
+
+	t.Run("MissingIdentityPassesWithoutPolicy", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, whoami.Register,
+			grpcservice.WithUnaryInterceptors(grpcservice.IdentityUnaryInterceptor(nil)))
+
+		// Exercise + Verify
+		response, err := harness.Invoke(context.Background(), "test.Identity", "Whoami", nil)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, len(response.Fields), 0)
+	})
+
+	t.Run("StreamsReceiveIdentity", func(t *testing.T) {
+		// SetUp
+		streaming := &grpctest.FakeService{Name: "test.IdentityStream",
+			Streams: map[string]grpctest.StreamHandler{
+				"Whoami": func(stream grpc.ServerStream) error {
+					identity, _ := grpcservice.IdentityFromContext(stream.Context())
+					response, _ := structpb.NewStruct(map[string]interface{}{
+						"commonName": identity.CommonName})
+					return stream.SendMsg(response)
+				}}}
+		serverCertFile, serverKeyFile := ca.ServerCert(t, "127.0.0.1").Files(t)
+		tempServer := grpcservice.NewMutualGRPCServer(true,
+			serverCertFile, serverKeyFile, ca.File(t), 0, grpcservice.WithHost("127.0.0.1"),
+			grpcservice.WithStreamInterceptors(grpcservice.IdentityStreamInterceptor(
+				&grpcservice.AuthorizationPolicy{DefaultAllow: true})))
+		streaming.Register(tempServer.GetInstance())
+		startServer(t, tempServer)
+		defer tempServer.Stop()
+
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+		clientCertFile, clientKeyFile := ca.ClientCert(t, "streamer").Files(t)
+		client := new(grpcservice.GRPCClient)
+		err := client.ConnectMutual(&grpcservice.ConnectionInfo{UseTLS: true,
+			CertFile: clientCertFile, KeyFile: clientKeyFile, CaFile: ca.File(t),
+			IP: "127.0.0.1", Port: port, TimeoutInMilliSecs: 1000})
+		test.AssertThat(t, err, nil)
+		defer client.Close()
+
+		// Exercise + Verify
+		stream, err := grpctest.NewStream(context.Background(), client.GetConnection(),
+			"test.IdentityStream", "Whoami")
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, stream.CloseSend(), nil)
+		response := new(structpb.Struct)
+		test.AssertThat(t, stream.RecvMsg(response), nil)
+		test.AssertThat(t, response.Fields["commonName"].GetStringValue(), "streamer")
+	})
+}
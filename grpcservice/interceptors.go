@@ -0,0 +1,122 @@
+package grpcservice
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// contextServerStream replaces the context of a server stream,
+// so stream interceptors can pass values on to the handler
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced context
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// withContext wraps the stream to return ctx from Context
+func withContext(stream grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &contextServerStream{ServerStream: stream, ctx: ctx}
+}
+
+// finishingClientStream calls finish once a client stream ended, either with io.EOF,
+// an error or the single response of a stream not streaming from the server.
+// The optional sent and received functions are called with every transferred message.
+type finishingClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	finish        func(err error)
+	sent          func(message interface{})
+	received      func(message interface{})
+	once          sync.Once
+	ended         chan struct{}
+}
+
+func (s *finishingClientStream) end(err error) {
+	s.once.Do(func() {
+		s.finish(err)
+		if s.ended != nil {
+			close(s.ended)
+		}
+	})
+}
+
+// watch also ends the stream once its context is done,
+// so streams cancelled or never read to the end are finished as well
+func (s *finishingClientStream) watch(ctx context.Context) *finishingClientStream {
+	s.ended = make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.end(status.FromContextError(ctx.Err()).Err())
+		case <-s.ended:
+		}
+	}()
+	return s
+}
+
+// SendMsg reports sent messages and ends the stream on errors
+func (s *finishingClientStream) SendMsg(message interface{}) error {
+	err := s.ClientStream.SendMsg(message)
+	if err == nil {
+		if s.sent != nil {
+			s.sent(message)
+		}
+	} else if err != io.EOF {
+		s.end(err)
+	}
+	return err
+}
+
+// RecvMsg reports received messages and ends the stream once it is done
+func (s *finishingClientStream) RecvMsg(message interface{}) error {
+	err := s.ClientStream.RecvMsg(message)
+	switch {
+	case err == io.EOF:
+		s.end(nil)
+	case err != nil:
+		s.end(err)
+	default:
+		if s.received != nil {
+			s.received(message)
+		}
+		if !s.serverStreams {
+			s.end(nil)
+		}
+	}
+	return err
+}
+
+// matchesMethod checks a full method name against a pattern being either
+// a full method name, "/package.Service/*" for a whole service or "*" for everything
+func matchesMethod(pattern, fullMethod string) bool {
+	if pattern == "*" || pattern == fullMethod {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") &&
+		strings.HasPrefix(fullMethod, strings.TrimSuffix(pattern, "*"))
+}
+
+// mostSpecificPattern returns the most specific method pattern known to exist
+// which matches the full method: the method itself, its service or "*"
+func mostSpecificPattern(fullMethod string, exists func(pattern string) bool) (string, bool) {
+	candidates := []string{fullMethod}
+	if index := strings.LastIndex(fullMethod, "/"); index >= 0 {
+		candidates = append(candidates, fullMethod[:index+1]+"*")
+	}
+	candidates = append(candidates, "*")
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,140 @@
+package grpcservice
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Limits grpc applies to keepalive settings
+const (
+	// minClientKeepaliveTime is the shortest ping interval of clients, grpc raises shorter ones
+	minClientKeepaliveTime = 10 * time.Second
+	// minServerKeepaliveTime is the shortest ping interval of servers, grpc raises shorter ones
+	minServerKeepaliveTime = time.Second
+	// defaultMinPingInterval is the interval servers enforce if ServerKeepalive.MinPingInterval is not set
+	defaultMinPingInterval = 5 * time.Minute
+)
+
+// ServerKeepalive configures keepalive pings and connection ages of a server and
+// the policy clients have to follow. Zero values keep the grpc defaults.
+type ServerKeepalive struct {
+	// Time pings clients after this idle time, 2h by default
+	Time time.Duration
+	// Timeout closes a connection if a ping is not answered in time, 20s by default
+	Timeout time.Duration
+	// MaxConnectionIdle closes connections without calls for this time, infinite by default
+	MaxConnectionIdle time.Duration
+	// MaxConnectionAge closes connections after this time, so clients reconnect and rebalance
+	MaxConnectionAge time.Duration
+	// MaxConnectionAgeGrace is the time calls get to complete once a connection is too old
+	MaxConnectionAgeGrace time.Duration
+
+	// MinPingInterval is the shortest interval clients may ping in, 5m by default.
+	// Clients pinging more often are closed with GOAWAY too_many_pings.
+	MinPingInterval time.Duration
+	// PermitWithoutStream allows clients to ping without active calls
+	PermitWithoutStream bool
+}
+
+// WithKeepalive sets the keepalive parameters and the enforcement policy of the server.
+// It is only supported by GRPCServer, the HTTP servers of GRPCWebServer and GRPCMuxServer
+// manage their connections themselves and reject it, see WithHTTPTimeouts instead.
+func WithKeepalive(params ServerKeepalive) ServerOption {
+	return func(o *serverOptions) {
+		o.keepalive = &params
+	}
+}
+
+// validate checks the settings for values grpc would silently change or reject
+func (k *ServerKeepalive) validate() error {
+	if k.Time < 0 || k.Timeout < 0 || k.MaxConnectionIdle < 0 || k.MaxConnectionAge < 0 ||
+		k.MaxConnectionAgeGrace < 0 || k.MinPingInterval < 0 {
+		return fmt.Errorf("GRPC server: Negative keepalive duration")
+	}
+	if k.Time > 0 && k.Time < minServerKeepaliveTime {
+		return fmt.Errorf("GRPC server: Keepalive time of %v is below the minimum of %v",
+			k.Time, minServerKeepaliveTime)
+	}
+	if k.MaxConnectionAgeGrace > 0 && k.MaxConnectionAge == 0 {
+		return fmt.Errorf("GRPC server: Keepalive connection age grace requires a maximum connection age")
+	}
+	return nil
+}
+
+// grpcOptions returns the keepalive options of the grpc server
+func (k *ServerKeepalive) grpcOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  k.Time,
+			Timeout:               k.Timeout,
+			MaxConnectionIdle:     k.MaxConnectionIdle,
+			MaxConnectionAge:      k.MaxConnectionAge,
+			MaxConnectionAgeGrace: k.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             k.minPingInterval(),
+			PermitWithoutStream: k.PermitWithoutStream,
+		}),
+	}
+}
+
+func (k *ServerKeepalive) minPingInterval() time.Duration {
+	if k.MinPingInterval > 0 {
+		return k.MinPingInterval
+	}
+	return defaultMinPingInterval
+}
+
+// keepaliveOption returns the keepalive parameters of the connection info, if any
+func keepaliveOption(info *ConnectionInfo) (grpc.DialOption, error) {
+	if info.KeepaliveTimeInMilliSecs < 0 || info.KeepaliveTimeoutInMilliSecs < 0 {
+		return nil, fmt.Errorf("GRPC client: Negative keepalive duration")
+	}
+	if info.KeepaliveTimeInMilliSecs == 0 {
+		if info.KeepaliveTimeoutInMilliSecs > 0 || info.KeepalivePermitWithoutStream {
+			return nil, fmt.Errorf("GRPC client: Keepalive settings require a keepalive time")
+		}
+		return nil, nil
+	}
+	params := keepalive.ClientParameters{
+		Time:                time.Duration(info.KeepaliveTimeInMilliSecs) * time.Millisecond,
+		Timeout:             time.Duration(info.KeepaliveTimeoutInMilliSecs) * time.Millisecond,
+		PermitWithoutStream: info.KeepalivePermitWithoutStream,
+	}
+	if params.Time < minClientKeepaliveTime {
+		return nil, fmt.Errorf("GRPC client: Keepalive time of %v is below the minimum of %v",
+			params.Time, minClientKeepaliveTime)
+	}
+	return grpc.WithKeepaliveParams(params), nil
+}
+
+// CheckKeepalive reports client keepalive settings which a server with the given
+// settings answers with GOAWAY too_many_pings, either because the client pings more
+// often than the server permits or without calls while the server does not allow it.
+// A nil server stands for a server with the grpc defaults.
+func CheckKeepalive(info *ConnectionInfo, server *ServerKeepalive) error {
+	if _, err := keepaliveOption(info); err != nil {
+		return err
+	}
+	if server == nil {
+		server = &ServerKeepalive{}
+	}
+	if err := server.validate(); err != nil {
+		return err
+	}
+	if info.KeepaliveTimeInMilliSecs == 0 {
+		return nil
+	}
+	clientTime := time.Duration(info.KeepaliveTimeInMilliSecs) * time.Millisecond
+	if clientTime < server.minPingInterval() {
+		return fmt.Errorf("GRPC client: Keepalive time of %v is below the minimum ping interval "+
+			"of %v permitted by the server", clientTime, server.minPingInterval())
+	}
+	if info.KeepalivePermitWithoutStream && !server.PermitWithoutStream {
+		return fmt.Errorf("GRPC client: Keepalive pings without calls are not permitted by the server")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package grpcservice_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestSuiteKeepalive(t *testing.T) {
+	t.Run("InvalidClientSettingsFail", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			info     grpcservice.ConnectionInfo
+			expected string
+		}{
+			"TimeBelowMinimum": {grpcservice.ConnectionInfo{KeepaliveTimeInMilliSecs: 5000},
+				"Keepalive time of 5s is below the minimum of 10s"},
+			"TimeoutWithoutTime": {grpcservice.ConnectionInfo{KeepaliveTimeoutInMilliSecs: 1000},
+				"Keepalive settings require a keepalive time"},
+			"PermitWithoutStreamWithoutTime": {grpcservice.ConnectionInfo{KeepalivePermitWithoutStream: true},
+				"Keepalive settings require a keepalive time"},
+			"NegativeTimeout": {grpcservice.ConnectionInfo{KeepaliveTimeoutInMilliSecs: -1},
+				"Negative keepalive duration"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				// SetUp
+				tc.info.IP, tc.info.Port = "127.0.0.1", "1"
+
+				// Exercise + Verify
+				err := new(grpcservice.GRPCClient).Connect(&tc.info)
+				test.AssertThat(t, err, "GRPC client: "+tc.expected, "streq")
+			})
+		}
+	})
+
+	t.Run("InvalidServerSettingsFail", func(t *testing.T) {
+		for name, params := range map[string]grpcservice.ServerKeepalive{
+			"TimeBelowMinimum":        {Time: 500 * time.Millisecond},
+			"GraceWithoutAge":         {MaxConnectionAgeGrace: time.Second},
+			"NegativeIdleTime":        {MaxConnectionIdle: -time.Second},
+			"NegativeMinPingInterval": {MinPingInterval: -time.Second},
+		} {
+			params := params
+			t.Run(name, func(t *testing.T) {
+				// Exercise + Verify
+				test.AssertThat(t, grpcservice.NewGRPCServer(false, "", "", 0,
+					grpcservice.WithHost("127.0.0.1"), grpcservice.WithKeepalive(params)) == nil, true)
+				test.AssertThat(t, grpcservice.NewGRPCWebServer(false, "", "", 0,
+					grpcservice.WithHost("127.0.0.1"), grpcservice.WithKeepalive(params)) == nil, true)
+			})
+		}
+	})
+
+	t.Run("HTTPServersRejectKeepalive", func(t *testing.T) {
+		// SetUp
+		params := grpcservice.ServerKeepalive{MaxConnectionIdle: time.Minute}
+
+		// Exercise + Verify
+		test.AssertThat(t, grpcservice.NewGRPCWebServer(false, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"), grpcservice.WithKeepalive(params)) == nil, true)
+		test.AssertThat(t, grpcservice.NewGRPCMuxServer(false, "", "", 0,
+			grpcservice.WithHost("127.0.0.1"), grpcservice.WithKeepalive(params)) == nil, true)
+	})
+
+	t.Run("CheckKeepaliveDetectsTooManyPings", func(t *testing.T) {
+		// SetUp
+		info := &grpcservice.ConnectionInfo{KeepaliveTimeInMilliSecs: 30000}
+
+		// Exercise + Verify
+		test.AssertThat(t, grpcservice.CheckKeepalive(info, nil),
+			"Keepalive time of 30s is below the minimum ping interval of 5m0s permitted by the server",
+			"contains")
+		test.AssertThat(t, grpcservice.CheckKeepalive(info,
+			&grpcservice.ServerKeepalive{MinPingInterval: 20 * time.Second}), nil)
+
+		info.KeepalivePermitWithoutStream = true
+		test.AssertThat(t, grpcservice.CheckKeepalive(info,
+			&grpcservice.ServerKeepalive{MinPingInterval: 20 * time.Second}),
+			"Keepalive pings without calls are not permitted by the server", "contains")
+		test.AssertThat(t, grpcservice.CheckKeepalive(info, &grpcservice.ServerKeepalive{
+			MinPingInterval: 20 * time.Second, PermitWithoutStream: true}), nil)
+		test.AssertThat(t, grpcservice.CheckKeepalive(&grpcservice.ConnectionInfo{}, nil), nil)
+	})
+
+	t.Run("IdleConnectionsAreClosedByServer", func(t *testing.T) {
+		// SetUp
+		harness := grpctest.New(t, newMeasured().Register, grpcservice.WithKeepalive(
+			grpcservice.ServerKeepalive{MaxConnectionIdle: 50 * time.Millisecond,
+				MinPingInterval: 10 * time.Second, PermitWithoutStream: true}))
+		harness.Client = harness.Connect(&grpcservice.ConnectionInfo{KeepaliveTimeInMilliSecs: 10000,
+			KeepaliveTimeoutInMilliSecs: 1000, KeepalivePermitWithoutStream: true})
+		_, err := harness.Invoke(context.Background(), "test.Measured", "Call", nil)
+		test.AssertThat(t, err, nil)
+
+		// Exercise + Verify
+		eventually(t, func() bool {
+			return harness.Connection().GetState() == connectivity.Idle
+		})
+		_, err = harness.Invoke(context.Background(), "test.Measured", "Call", nil)
+		test.AssertThat(t, err, nil)
+	})
+}
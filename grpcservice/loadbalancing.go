@@ -0,0 +1,94 @@
+package grpcservice
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	_ "google.golang.org/grpc/health" // enables client side health checking
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// endpointsScheme is the scheme of the resolver serving ConnectionInfo.Endpoints
+const endpointsScheme = "endpoints"
+
+// Load balancing policies of ConnectionInfo.LoadBalancing
+const (
+	// LoadBalancingPickFirst sends all calls to the first reachable endpoint
+	LoadBalancingPickFirst = "pick_first"
+	// LoadBalancingRoundRobin spreads calls over all ready endpoints
+	LoadBalancingRoundRobin = "round_robin"
+)
+
+// serviceConfig is the part of the grpc service config set by the connection info
+type serviceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig,omitempty"`
+	HealthCheckConfig   *healthCheckConfig    `json:"healthCheckConfig,omitempty"`
+}
+
+type healthCheckConfig struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// serviceConfigOption returns the service config selecting load balancing and health checking
+func serviceConfigOption(info *ConnectionInfo) (grpc.DialOption, error) {
+	if info.LoadBalancing == "" && !info.HealthCheck {
+		return nil, nil
+	}
+	policy := info.LoadBalancing
+	if policy == "" {
+		policy = LoadBalancingRoundRobin
+	}
+	if policy != LoadBalancingPickFirst && policy != LoadBalancingRoundRobin {
+		return nil, fmt.Errorf("GRPC client: Unknown load balancing policy %q", policy)
+	}
+	if info.HealthCheck && policy == LoadBalancingPickFirst {
+		return nil, fmt.Errorf("GRPC client: Health checking requires the %s policy",
+			LoadBalancingRoundRobin)
+	}
+	config := serviceConfig{LoadBalancingConfig: []map[string]struct{}{{policy: {}}}}
+	if info.HealthCheck {
+		config.HealthCheckConfig = &healthCheckConfig{ServiceName: info.HealthCheckServiceName}
+	}
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithDefaultServiceConfig(string(encoded)), nil
+}
+
+// resolverState returns the resolver state listing the endpoints
+func resolverState(endpoints []string) resolver.State {
+	addresses := make([]resolver.Address, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, resolver.Address{Addr: endpoint})
+	}
+	return resolver.State{Addresses: addresses}
+}
+
+// newEndpointsResolver returns a resolver serving the endpoints of the info
+func newEndpointsResolver(endpoints []string) *manual.Resolver {
+	r := manual.NewBuilderWithScheme(endpointsScheme)
+	r.InitialState(resolverState(endpoints))
+	return r
+}
+
+// UpdateEndpoints replaces the endpoints of a client connected with ConnectionInfo.Endpoints.
+// Calls in flight complete, new calls are balanced across the new endpoints.
+func (grpcclient *GRPCClient) UpdateEndpoints(endpoints []string) error {
+	if grpcclient.resolver == nil {
+		return fmt.Errorf("GRPC client: Not connected to a list of endpoints")
+	}
+	if len(endpoints) == 0 {
+		return fmt.Errorf("GRPC client: Empty list of endpoints")
+	}
+	if grpcclient.connection.GetState() == connectivity.Shutdown {
+		return fmt.Errorf("GRPC client: Connection is closed")
+	}
+	// the resolver is only built once the connection leaves idle mode
+	grpcclient.connection.Connect()
+	grpcclient.resolver.UpdateState(resolverState(endpoints))
+	return nil
+}
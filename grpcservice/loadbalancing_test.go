@@ -0,0 +1,178 @@
+package grpcservice_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// backend is a server answering with its name and implementing the health protocol
+type backend struct {
+	name    string
+	address string
+	health  *health.Server
+}
+
+// startBackends starts the named backends on ephemeral ports, they are stopped after the test
+func startBackends(t *testing.T, names ...string) []*backend {
+	var backends []*backend
+	for _, name := range names {
+		name := name
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+		(&grpctest.FakeService{
+			Name: "test.Backend",
+			Unary: map[string]grpctest.UnaryHandler{
+				"Name": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+					return structpb.NewStruct(map[string]interface{}{"name": name})
+				},
+			},
+		}).Register(tempServer.GetInstance())
+		healthServer := health.NewServer()
+		healthpb.RegisterHealthServer(tempServer.GetInstance(), healthServer)
+		startServer(t, tempServer)
+		t.Cleanup(func() {
+			tempServer.Stop()
+		})
+		backends = append(backends, &backend{name: name,
+			address: tempServer.Addr().String(), health: healthServer})
+	}
+	return backends
+}
+
+func addresses(backends ...*backend) []string {
+	var result []string
+	for _, b := range backends {
+		result = append(result, b.address)
+	}
+	return result
+}
+
+// callNames calls the backends the given number of times and counts the answers per backend
+func callNames(t *testing.T, client *grpcservice.GRPCClient, calls int) map[string]int {
+	names := make(map[string]int)
+	for i := 0; i < calls; i++ {
+		response, err := grpctest.Invoke(context.Background(), client.GetConnection(),
+			"test.Backend", "Name", nil)
+		test.AssertThat(t, err, nil)
+		names[response.Fields["name"].GetStringValue()]++
+	}
+	return names
+}
+
+// eventually retries the condition for up to two seconds
+func eventually(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSuiteLoadBalancing(t *testing.T) {
+	t.Run("RoundRobinSpreadsCalls", func(t *testing.T) {
+		// SetUp
+		backends := startBackends(t, "a", "b", "c")
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			Endpoints: addresses(backends...), LoadBalancing: grpcservice.LoadBalancingRoundRobin,
+			TimeoutInMilliSecs: 1000}), nil)
+		defer tempClient.Close()
+
+		// Exercise + Verify
+		eventually(t, func() bool {
+			return len(callNames(t, tempClient, 30)) == 3
+		})
+	})
+
+	t.Run("PickFirstUsesFirstEndpoint", func(t *testing.T) {
+		// SetUp
+		backends := startBackends(t, "a", "b")
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			Endpoints: addresses(backends...), LoadBalancing: grpcservice.LoadBalancingPickFirst,
+			TimeoutInMilliSecs: 1000}), nil)
+		defer tempClient.Close()
+
+		// Exercise + Verify
+		test.AssertThat(t, callNames(t, tempClient, 10)["a"], 10)
+	})
+
+	t.Run("UnhealthyEndpointsAreEjected", func(t *testing.T) {
+		// SetUp
+		backends := startBackends(t, "a", "b")
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			Endpoints: addresses(backends...), HealthCheck: true,
+			TimeoutInMilliSecs: 1000}), nil)
+		defer tempClient.Close()
+
+		// Exercise
+		backends[1].health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+		// Verify
+		eventually(t, func() bool {
+			return callNames(t, tempClient, 20)["a"] == 20
+		})
+		backends[1].health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		eventually(t, func() bool {
+			return callNames(t, tempClient, 20)["b"] > 0
+		})
+	})
+
+	t.Run("EndpointsAreUpdatable", func(t *testing.T) {
+		// SetUp
+		backends := startBackends(t, "a", "b")
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			Endpoints: addresses(backends[0]), TimeoutInMilliSecs: 1000}), nil)
+		defer tempClient.Close()
+		test.AssertThat(t, callNames(t, tempClient, 5)["a"], 5)
+
+		// Exercise
+		test.AssertThat(t, tempClient.UpdateEndpoints(addresses(backends[1])), nil)
+
+		// Verify
+		eventually(t, func() bool {
+			return callNames(t, tempClient, 5)["b"] == 5
+		})
+		test.AssertThat(t, tempClient.UpdateEndpoints(nil), "Empty list of endpoints", "contains")
+	})
+
+	t.Run("UpdatingEndpointsFailsWithoutEndpoints", func(t *testing.T) {
+		// SetUp
+		backends := startBackends(t, "a")
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			Target: "passthrough:///" + backends[0].address}), nil)
+		defer tempClient.Close()
+
+		// Exercise + Verify
+		test.AssertThat(t, callNames(t, tempClient, 1)["a"], 1)
+		test.AssertThat(t, tempClient.UpdateEndpoints(addresses(backends...)),
+			"Not connected to a list of endpoints", "contains")
+	})
+
+	t.Run("UnknownPolicyFails", func(t *testing.T) {
+		// Exercise + Verify
+		err := new(grpcservice.GRPCClient).Connect(&grpcservice.ConnectionInfo{
+			Endpoints: []string{"127.0.0.1:1"}, LoadBalancing: "random"})
+		test.AssertThat(t, err, "Unknown load balancing policy", "contains")
+	})
+
+	t.Run("HealthCheckWithPickFirstFails", func(t *testing.T) {
+		// Exercise + Verify
+		err := new(grpcservice.GRPCClient).Connect(&grpcservice.ConnectionInfo{
+			Endpoints: []string{"127.0.0.1:1"}, HealthCheck: true,
+			LoadBalancing: grpcservice.LoadBalancingPickFirst})
+		test.AssertThat(t, err, "GRPC client: Health checking requires the round_robin policy", "contains")
+	})
+}
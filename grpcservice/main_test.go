@@ -39,3 +39,15 @@ func TestMain(m *testing.M) {
 
 	os.Exit(testreturn)
 }
+
+// startServer serves the server in the background and waits until it runs
+func startServer(t *testing.T, grpcServer *grpcservice.GRPCServer) {
+	go grpcServer.Serve()
+	deadline := time.Now().Add(time.Second)
+	for !grpcServer.IsRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start serving")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
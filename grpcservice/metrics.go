@@ -0,0 +1,511 @@
+package grpcservice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Timeouts of the HTTP server started by ListenAndServe, scrapes send no body
+const (
+	metricsReadHeaderTimeout = 10 * time.Second
+	metricsIdleTimeout       = time.Minute
+)
+
+// metric kinds as named in the exposition format
+const (
+	counterKind   = "counter"
+	gaugeKind     = "gauge"
+	histogramKind = "histogram"
+)
+
+var (
+	// latencyBuckets are the upper bounds of the handling time histograms in seconds
+	latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// sizeBuckets are the upper bounds of the message size histograms in bytes
+	sizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+	// statusStates are all lifecycle states exported by the status gauge
+	statusStates = []server.Status{server.StateUndefined, server.StateInitialized,
+		server.StateStarting, server.StateStarted, server.StateRunning,
+		server.StateStopping, server.StateStopped, server.StateError}
+)
+
+// metricFamily is a named metric with all its labelled series
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// metricSeries is a single labelled value, histograms count observations per bucket
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// Metrics collects request counts, latencies, in-flight calls and message sizes
+// of grpc servers and clients through interceptors, as well as the lifecycle state
+// of servers and the states of client circuit breakers, and exposes them in the
+// Prometheus text exposition format.
+// A single Metrics instance can be shared by any number of servers and clients.
+type Metrics struct {
+	mutex    sync.Mutex
+	families []*metricFamily
+
+	serverStarted  *metricFamily
+	serverHandled  *metricFamily
+	serverHandling *metricFamily
+	serverInFlight *metricFamily
+	serverReceived *metricFamily
+	serverSent     *metricFamily
+
+	clientStarted  *metricFamily
+	clientHandled  *metricFamily
+	clientHandling *metricFamily
+	clientInFlight *metricFamily
+	clientReceived *metricFamily
+	clientSent     *metricFamily
+
+	serverStatus *metricFamily
+
+	circuitState    *metricFamily
+	circuitRejected *metricFamily
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	labels := []string{"grpc_type", "grpc_service", "grpc_method"}
+	handledLabels := append(append([]string{}, labels...), "grpc_code")
+
+	m.serverStarted = m.family("grpc_server_started_total",
+		"Total number of RPCs started on the server.", counterKind, labels, nil)
+	m.serverHandled = m.family("grpc_server_handled_total",
+		"Total number of RPCs completed on the server, regardless of success or failure.",
+		counterKind, handledLabels, nil)
+	m.serverHandling = m.family("grpc_server_handling_seconds",
+		"Histogram of response latency of RPCs handled by the server.",
+		histogramKind, labels, latencyBuckets)
+	m.serverInFlight = m.family("grpc_server_in_flight",
+		"Number of RPCs currently handled by the server.", gaugeKind, labels, nil)
+	m.serverReceived = m.family("grpc_server_msg_received_bytes",
+		"Histogram of message sizes received by the server.",
+		histogramKind, labels, sizeBuckets)
+	m.serverSent = m.family("grpc_server_msg_sent_bytes",
+		"Histogram of message sizes sent by the server.",
+		histogramKind, labels, sizeBuckets)
+
+	m.clientStarted = m.family("grpc_client_started_total",
+		"Total number of RPCs started by the client.", counterKind, labels, nil)
+	m.clientHandled = m.family("grpc_client_handled_total",
+		"Total number of RPCs completed by the client, regardless of success or failure.",
+		counterKind, handledLabels, nil)
+	m.clientHandling = m.family("grpc_client_handling_seconds",
+		"Histogram of response latency of RPCs until completed by the client.",
+		histogramKind, labels, latencyBuckets)
+	m.clientInFlight = m.family("grpc_client_in_flight",
+		"Number of RPCs currently in flight on the client.", gaugeKind, labels, nil)
+	m.clientReceived = m.family("grpc_client_msg_received_bytes",
+		"Histogram of message sizes received by the client.",
+		histogramKind, labels, sizeBuckets)
+	m.clientSent = m.family("grpc_client_msg_sent_bytes",
+		"Histogram of message sizes sent by the client.",
+		histogramKind, labels, sizeBuckets)
+
+	m.serverStatus = m.family("grpc_server_status",
+		"Lifecycle state of the server, 1 for the current state and 0 otherwise.",
+		gaugeKind, []string{"server", "state"}, nil)
+
+	m.circuitState = m.family("grpc_client_circuit_state",
+		"State of the client circuit breakers, 1 for the current state and 0 otherwise.",
+		gaugeKind, []string{"circuit", "state"}, nil)
+	m.circuitRejected = m.family("grpc_client_circuit_rejected_total",
+		"Total number of RPCs failed fast by open client circuits.",
+		counterKind, []string{"circuit"}, nil)
+	return m
+}
+
+func (m *Metrics) family(name, help, kind string, labels []string, buckets []float64) *metricFamily {
+	f := &metricFamily{name: name, help: help, kind: kind, labels: labels,
+		buckets: buckets, series: make(map[string]*metricSeries)}
+	m.families = append(m.families, f)
+	return f
+}
+
+// get returns the series for the label values, it has to be called with the mutex held
+func (f *metricFamily) get(labelValues ...string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if f.kind == histogramKind {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// observe adds a value to a histogram series, it has to be called with the mutex held
+func (f *metricFamily) observe(value float64, labelValues ...string) {
+	s := f.get(labelValues...)
+	for i, bound := range f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// SetStatus exports the lifecycle state of the named server
+func (m *Metrics) SetStatus(serverName string, state server.Status) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, candidate := range statusStates {
+		value := 0.0
+		if candidate == state {
+			value = 1
+		}
+		m.serverStatus.get(serverName, candidate.String()).value = value
+	}
+}
+
+// SetCircuitState exports the state of a circuit breaker circuit
+func (m *Metrics) SetCircuitState(circuit string, state CircuitState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, candidate := range circuitStates {
+		value := 0.0
+		if candidate == state {
+			value = 1
+		}
+		m.circuitState.get(circuit, candidate.String()).value = value
+	}
+}
+
+// rejectCircuitCall counts a call failed fast by an open circuit
+func (m *Metrics) rejectCircuitCall(circuit string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.circuitRejected.get(circuit).value++
+}
+
+// ObserveStatus exports every state sent to the status channel of the logger
+func (m *Metrics) ObserveStatus(logger *server.Logger) {
+	serverName := logger.ServerName()
+	m.SetStatus(serverName, server.StateUndefined)
+	logger.OnStatus(func(state server.Status) {
+		m.SetStatus(serverName, state)
+	})
+}
+
+// callLabels returns the type, service and method labels of a call
+func callLabels(fullMethod string, clientStream, serverStream bool) []string {
+	grpcType := "unary"
+	switch {
+	case clientStream && serverStream:
+		grpcType = "bidi_stream"
+	case clientStream:
+		grpcType = "client_stream"
+	case serverStream:
+		grpcType = "server_stream"
+	}
+	service, method := "unknown", "unknown"
+	if parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2); len(parts) == 2 {
+		service, method = parts[0], parts[1]
+	}
+	return []string{grpcType, service, method}
+}
+
+// callMetrics are the families recording one side of a call
+type callMetrics struct {
+	started, handled, handling, inFlight, received, sent *metricFamily
+}
+
+func (m *Metrics) serverMetrics() callMetrics {
+	return callMetrics{m.serverStarted, m.serverHandled, m.serverHandling,
+		m.serverInFlight, m.serverReceived, m.serverSent}
+}
+
+func (m *Metrics) clientMetrics() callMetrics {
+	return callMetrics{m.clientStarted, m.clientHandled, m.clientHandling,
+		m.clientInFlight, m.clientReceived, m.clientSent}
+}
+
+// start records the start of a call and returns the function recording its end
+func (m *Metrics) start(c callMetrics, labels []string) func(err error) {
+	begin := time.Now()
+	m.mutex.Lock()
+	c.started.get(labels...).value++
+	c.inFlight.get(labels...).value++
+	m.mutex.Unlock()
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			code := status.Code(err).String()
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			c.inFlight.get(labels...).value--
+			c.handled.get(append(append([]string{}, labels...), code)...).value++
+			c.handling.observe(time.Since(begin).Seconds(), labels...)
+		})
+	}
+}
+
+// observeMessage records the size of a protobuf message, other messages are ignored
+func (m *Metrics) observeMessage(f *metricFamily, message interface{}, labels []string) {
+	msg, ok := message.(proto.Message)
+	if !ok {
+		return
+	}
+	size := float64(proto.Size(msg))
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	f.observe(size, labels...)
+}
+
+// UnaryServerInterceptor records the metrics of unary calls handled by a server
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		c := m.serverMetrics()
+		labels := callLabels(info.FullMethod, false, false)
+		finish := m.start(c, labels)
+		m.observeMessage(c.received, request, labels)
+		response, err := handler(ctx, request)
+		if err == nil {
+			m.observeMessage(c.sent, response, labels)
+		}
+		finish(err)
+		return response, err
+	}
+}
+
+// StreamServerInterceptor records the metrics of streaming calls handled by a server
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		c := m.serverMetrics()
+		labels := callLabels(info.FullMethod, info.IsClientStream, info.IsServerStream)
+		finish := m.start(c, labels)
+		err := handler(srv, &metricsServerStream{ServerStream: stream,
+			metrics: m, calls: c, labels: labels})
+		finish(err)
+		return err
+	}
+}
+
+// metricsServerStream records the sizes of streamed messages
+type metricsServerStream struct {
+	grpc.ServerStream
+	metrics *Metrics
+	calls   callMetrics
+	labels  []string
+}
+
+// SendMsg records the size of sent messages
+func (s *metricsServerStream) SendMsg(message interface{}) error {
+	err := s.ServerStream.SendMsg(message)
+	if err == nil {
+		s.metrics.observeMessage(s.calls.sent, message, s.labels)
+	}
+	return err
+}
+
+// RecvMsg records the size of received messages
+func (s *metricsServerStream) RecvMsg(message interface{}) error {
+	err := s.ServerStream.RecvMsg(message)
+	if err == nil {
+		s.metrics.observeMessage(s.calls.received, message, s.labels)
+	}
+	return err
+}
+
+// UnaryClientInterceptor records the metrics of unary calls made by a client
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, request, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c := m.clientMetrics()
+		labels := callLabels(method, false, false)
+		finish := m.start(c, labels)
+		m.observeMessage(c.sent, request, labels)
+		err := invoker(ctx, method, request, reply, cc, opts...)
+		if err == nil {
+			m.observeMessage(c.received, reply, labels)
+		}
+		finish(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor records the metrics of streaming calls made by a client.
+// A stream counts as completed once it ended with an error or io.EOF or its context ended.
+func (m *Metrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		c := m.clientMetrics()
+		labels := callLabels(method, desc.ClientStreams, desc.ServerStreams)
+		finish := m.start(c, labels)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(err)
+			return nil, err
+		}
+		return (&finishingClientStream{ClientStream: stream, serverStreams: desc.ServerStreams,
+			finish: finish,
+			sent: func(message interface{}) {
+				m.observeMessage(c.sent, message, labels)
+			},
+			received: func(message interface{}) {
+				m.observeMessage(c.received, message, labels)
+			}}).watch(ctx), nil
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format. The metrics are
+// formatted under the lock and written afterwards, so slow readers do not hold up calls.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	m.format(&buffer)
+	return buffer.WriteTo(w)
+}
+
+// format writes a snapshot of all metrics to the buffer
+func (m *Metrics) format(buffer *bytes.Buffer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, f := range m.families {
+		if len(f.series) == 0 {
+			continue
+		}
+		fmt.Fprintf(buffer, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(buffer, "# TYPE %s %s\n", f.name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != histogramKind {
+				writeSample(buffer, f.name, f.labels, s.labelValues, "", s.value)
+				continue
+			}
+			for i, bound := range f.buckets {
+				writeSample(buffer, f.name+"_bucket", f.labels, s.labelValues,
+					formatFloat(bound), float64(s.counts[i]))
+			}
+			writeSample(buffer, f.name+"_bucket", f.labels, s.labelValues,
+				"+Inf", float64(s.count))
+			writeSample(buffer, f.name+"_sum", f.labels, s.labelValues, "", s.sum)
+			writeSample(buffer, f.name+"_count", f.labels, s.labelValues, "", float64(s.count))
+		}
+	}
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	m.WriteTo(w)
+}
+
+// ListenAndServe serves the metrics on the given address under /metrics.
+// It blocks like http.ListenAndServe and is only needed when the metrics
+// are not mounted on an existing HTTP server.
+func (m *Metrics) ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: metricsReadHeaderTimeout,
+		IdleTimeout:       metricsIdleTimeout,
+	}
+	return httpServer.ListenAndServe()
+}
+
+func writeSample(w io.Writer, name string, labels, labelValues []string, le string, value float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 || le != "" {
+		io.WriteString(w, "{")
+		for i, label := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "le=\"%s\"", le)
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(value))
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// WithMetrics records the metrics of all calls handled by the server
+func WithMetrics(metrics *Metrics) ServerOption {
+	return func(o *serverOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, metrics.UnaryServerInterceptor())
+		o.streamInterceptors = append(o.streamInterceptors, metrics.StreamServerInterceptor())
+	}
+}
@@ -0,0 +1,210 @@
+package grpcservice_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newMeasured returns a fake service with a succeeding, a failing and a streaming method
+func newMeasured() *grpctest.FakeService {
+	return &grpctest.FakeService{
+		Name: "test.Measured",
+		Unary: map[string]grpctest.UnaryHandler{
+			"Call": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return request, nil
+			},
+			"Fail": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return nil, status.Error(codes.NotFound, "Nothing here")
+			},
+		},
+		Streams: map[string]grpctest.StreamHandler{
+			"Stream": func(stream grpc.ServerStream) error {
+				message := new(structpb.Struct)
+				for {
+					if err := stream.RecvMsg(message); err == io.EOF {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					if err := stream.SendMsg(message); err != nil {
+						return err
+					}
+				}
+			},
+		},
+	}
+}
+
+// scrape returns the metrics as served over HTTP
+func scrape(t *testing.T, metrics *grpcservice.Metrics) string {
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	test.AssertThat(t, recorder.Code, http.StatusOK)
+	test.AssertThat(t, recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4", "contains")
+	return recorder.Body.String()
+}
+
+func TestSuiteMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("UnaryCallsAreMeasured", func(t *testing.T) {
+		// SetUp
+		metrics := grpcservice.NewMetrics()
+		harness := grpctest.New(t, newMeasured().Register, grpcservice.WithMetrics(metrics))
+		client := harness.Connect(&grpcservice.ConnectionInfo{
+			UnaryInterceptors: []grpc.UnaryClientInterceptor{metrics.UnaryClientInterceptor()}})
+		request, _ := structpb.NewStruct(map[string]interface{}{"key": "value"})
+
+		// Exercise
+		_, err := grpctest.Invoke(ctx, client.GetConnection(), "test.Measured", "Call", request)
+		test.AssertThat(t, err, nil)
+		_, err = grpctest.Invoke(ctx, client.GetConnection(), "test.Measured", "Fail", nil)
+		test.AssertThat(t, status.Code(err), codes.NotFound)
+
+		// Verify
+		exposition := scrape(t, metrics)
+		for _, expected := range []string{
+			"# TYPE grpc_server_started_total counter\n",
+			`grpc_server_started_total{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call"} 1` + "\n",
+			`grpc_server_handled_total{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call",grpc_code="OK"} 1` + "\n",
+			`grpc_server_handled_total{grpc_type="unary",grpc_service="test.Measured",grpc_method="Fail",grpc_code="NotFound"} 1` + "\n",
+			`grpc_server_in_flight{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call"} 0` + "\n",
+			"# TYPE grpc_server_handling_seconds histogram\n",
+			`grpc_server_handling_seconds_bucket{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call",le="+Inf"} 1` + "\n",
+			`grpc_server_handling_seconds_count{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call"} 1` + "\n",
+			`grpc_server_msg_received_bytes_count{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call"} 1` + "\n",
+			`grpc_server_msg_sent_bytes_bucket{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call",le="64"} 1` + "\n",
+			`grpc_client_started_total{grpc_type="unary",grpc_service="test.Measured",grpc_method="Fail"} 1` + "\n",
+			`grpc_client_handled_total{grpc_type="unary",grpc_service="test.Measured",grpc_method="Fail",grpc_code="NotFound"} 1` + "\n",
+			`grpc_client_msg_sent_bytes_count{grpc_type="unary",grpc_service="test.Measured",grpc_method="Call"} 1` + "\n",
+		} {
+			test.AssertThat(t, exposition, expected, "contains")
+		}
+	})
+
+	t.Run("StreamsAreMeasured", func(t *testing.T) {
+		// SetUp
+		metrics := grpcservice.NewMetrics()
+		harness := grpctest.New(t, newMeasured().Register, grpcservice.WithMetrics(metrics))
+		client := harness.Connect(&grpcservice.ConnectionInfo{
+			StreamInterceptors: []grpc.StreamClientInterceptor{metrics.StreamClientInterceptor()}})
+
+		// Exercise
+		stream, err := grpctest.NewStream(ctx, client.GetConnection(), "test.Measured", "Stream")
+		test.AssertThat(t, err, nil)
+		for i := 0; i < 3; i++ {
+			test.AssertThat(t, stream.SendMsg(new(structpb.Struct)), nil)
+			test.AssertThat(t, stream.RecvMsg(new(structpb.Struct)), nil)
+		}
+		test.AssertThat(t, stream.CloseSend(), nil)
+		test.AssertThat(t, stream.RecvMsg(new(structpb.Struct)), io.EOF)
+
+		// Verify
+		exposition := scrape(t, metrics)
+		for _, expected := range []string{
+			`grpc_server_handled_total{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream",grpc_code="OK"} 1` + "\n",
+			`grpc_server_msg_received_bytes_count{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream"} 3` + "\n",
+			`grpc_server_msg_sent_bytes_count{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream"} 3` + "\n",
+			`grpc_client_handled_total{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream",grpc_code="OK"} 1` + "\n",
+			`grpc_client_in_flight{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream"} 0` + "\n",
+			`grpc_client_msg_received_bytes_count{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream"} 3` + "\n",
+		} {
+			test.AssertThat(t, exposition, expected, "contains")
+		}
+	})
+
+	t.Run("CancelledStreamsAreNoLongerInFlight", func(t *testing.T) {
+		// SetUp
+		metrics := grpcservice.NewMetrics()
+		harness := grpctest.New(t, newMeasured().Register)
+		client := harness.Connect(&grpcservice.ConnectionInfo{
+			StreamInterceptors: []grpc.StreamClientInterceptor{metrics.StreamClientInterceptor()}})
+		streamCtx, cancel := context.WithCancel(ctx)
+		stream, err := grpctest.NewStream(streamCtx, client.GetConnection(), "test.Measured", "Stream")
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, stream.SendMsg(new(structpb.Struct)), nil)
+		inFlight := `grpc_client_in_flight{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream"} `
+		test.AssertThat(t, scrape(t, metrics), inFlight+"1\n", "contains")
+
+		// Exercise
+		cancel()
+
+		// Verify
+		eventually(t, func() bool {
+			return strings.Contains(scrape(t, metrics), inFlight+"0\n")
+		})
+		test.AssertThat(t, scrape(t, metrics),
+			`grpc_client_handled_total{grpc_type="bidi_stream",grpc_service="test.Measured",grpc_method="Stream",grpc_code="Canceled"} 1`, "contains")
+	})
+
+	t.Run("StatusIsExported", func(t *testing.T) {
+		// SetUp
+		metrics := grpcservice.NewMetrics()
+		logger := server.NewLogger(`My "Server"`, "", "", nil, nil, nil, nil, nil, server.Quiet)
+		metrics.ObserveStatus(logger)
+		test.AssertThat(t, logger.StartLogger(), nil)
+
+		// Exercise
+		logger.StatusChan <- server.StateRunning
+		logger.StopLogger()
+
+		// Verify
+		exposition := scrape(t, metrics)
+		test.AssertThat(t, exposition, "# TYPE grpc_server_status gauge\n", "contains")
+		test.AssertThat(t, exposition,
+			`grpc_server_status{server="My \"Server\"",state="StateRunning"} 1`+"\n", "contains")
+		test.AssertThat(t, exposition,
+			`grpc_server_status{server="My \"Server\"",state="StateUndefined"} 0`+"\n", "contains")
+	})
+
+	t.Run("SlowScrapesDoNotHoldUpCalls", func(t *testing.T) {
+		// SetUp
+		metrics := grpcservice.NewMetrics()
+		harness := grpctest.New(t, newMeasured().Register, grpcservice.WithMetrics(metrics))
+		client := harness.Connect(&grpcservice.ConnectionInfo{})
+		_, err := grpctest.Invoke(ctx, client.GetConnection(), "test.Measured", "Call", nil)
+		test.AssertThat(t, err, nil)
+		reader, writer := io.Pipe()
+		go metrics.WriteTo(writer)
+		defer reader.Close()
+
+		// Exercise
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, err = grpctest.Invoke(callCtx, client.GetConnection(), "test.Measured", "Call", nil)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("UnusedMetricsAreOmitted", func(t *testing.T) {
+		// Exercise + Verify
+		test.AssertThat(t, scrape(t, grpcservice.NewMetrics()), "")
+	})
+
+	t.Run("OnlyGetAndHeadAreServed", func(t *testing.T) {
+		// SetUp
+		recorder := httptest.NewRecorder()
+
+		// Exercise
+		grpcservice.NewMetrics().ServeHTTP(recorder,
+			httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader("")))
+
+		// Verify
+		test.AssertThat(t, recorder.Code, http.StatusMethodNotAllowed)
+	})
+}
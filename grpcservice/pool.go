@@ -0,0 +1,217 @@
+package grpcservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// GRPCClientPool keeps several connections to the same server to get past the
+// concurrent stream limit of a single HTTP/2 connection. Calls and streams go to the
+// connection with the fewest calls in flight, broken connections are replaced.
+type GRPCClientPool struct {
+	size int
+
+	mutex   sync.Mutex
+	info    ConnectionInfo
+	connect func(ctx context.Context, client *GRPCClient, info *ConnectionInfo) error
+	conns   []*pooledConnection
+	closed  bool
+}
+
+// pooledConnection is a pool slot counting the calls in flight on its client
+type pooledConnection struct {
+	client    *GRPCClient
+	inFlight  int64
+	replacing bool
+}
+
+// NewGRPCClientPool creates a pool of the given number of connections, connect it with Connect
+func NewGRPCClientPool(size int) *GRPCClientPool {
+	return &GRPCClientPool{size: size}
+}
+
+// Connect opens all connections of the pool, see GRPCClient.Connect
+func (pool *GRPCClientPool) Connect(info *ConnectionInfo) error {
+	return pool.ConnectContext(context.Background(), info)
+}
+
+// ConnectContext opens all connections of the pool, see GRPCClient.ConnectContext
+func (pool *GRPCClientPool) ConnectContext(ctx context.Context, info *ConnectionInfo) error {
+	return pool.open(ctx, info, (*GRPCClient).ConnectContext)
+}
+
+// ConnectMutual opens all connections of the pool with mutual TLS, see GRPCClient.ConnectMutual
+func (pool *GRPCClientPool) ConnectMutual(info *ConnectionInfo) error {
+	return pool.ConnectMutualContext(context.Background(), info)
+}
+
+// ConnectMutualContext opens all connections of the pool with mutual TLS,
+// see GRPCClient.ConnectMutualContext
+func (pool *GRPCClientPool) ConnectMutualContext(ctx context.Context, info *ConnectionInfo) error {
+	return pool.open(ctx, info, (*GRPCClient).ConnectMutualContext)
+}
+
+func (pool *GRPCClientPool) open(ctx context.Context, info *ConnectionInfo,
+	connect func(*GRPCClient, context.Context, *ConnectionInfo) error) error {
+	if pool.size <= 0 {
+		return fmt.Errorf("GRPC client pool: Invalid size %d", pool.size)
+	}
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.conns != nil {
+		return fmt.Errorf("GRPC client pool: Already connected")
+	}
+
+	pool.info = *info
+	pool.connect = func(ctx context.Context, client *GRPCClient, info *ConnectionInfo) error {
+		return connect(client, ctx, info)
+	}
+	conns := make([]*pooledConnection, 0, pool.size)
+	for i := 0; i < pool.size; i++ {
+		client := new(GRPCClient)
+		if err := pool.connect(ctx, client, &pool.info); err != nil {
+			for _, conn := range conns {
+				conn.client.Close()
+			}
+			return err
+		}
+		conns = append(conns, &pooledConnection{client: client})
+	}
+	pool.conns = conns
+	pool.closed = false
+	return nil
+}
+
+// GetConnection returns the pool as connection to create service clients with
+func (pool *GRPCClientPool) GetConnection() grpc.ClientConnInterface {
+	return pool
+}
+
+// Connections returns the connections currently in the pool
+func (pool *GRPCClientPool) Connections() []*grpc.ClientConn {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	connections := make([]*grpc.ClientConn, 0, len(pool.conns))
+	for _, conn := range pool.conns {
+		connections = append(connections, conn.client.GetConnection())
+	}
+	return connections
+}
+
+// broken reports whether a connection cannot take calls right now
+func broken(state connectivity.State) bool {
+	return state == connectivity.TransientFailure || state == connectivity.Shutdown
+}
+
+// pick returns the working connection with the fewest calls in flight and starts
+// replacing closed connections. Connections in transient failure are avoided but kept,
+// grpc reconnects them on its own. The call has to be released with done.
+func (pool *GRPCClientPool) pick() (*pooledConnection, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.closed || pool.conns == nil {
+		return nil, errors.New("GRPC client pool: Is not connected")
+	}
+
+	var best *pooledConnection
+	bestBroken := true
+	for _, conn := range pool.conns {
+		state := conn.client.GetConnection().GetState()
+		isBroken := broken(state)
+		if state == connectivity.Shutdown && !conn.replacing {
+			conn.replacing = true
+			go pool.replace(conn)
+		}
+		inFlight := atomic.LoadInt64(&conn.inFlight)
+		if best == nil || (bestBroken && !isBroken) ||
+			(bestBroken == isBroken && inFlight < atomic.LoadInt64(&best.inFlight)) {
+			best, bestBroken = conn, isBroken
+		}
+	}
+	atomic.AddInt64(&best.inFlight, 1)
+	return best, nil
+}
+
+func (conn *pooledConnection) done() {
+	atomic.AddInt64(&conn.inFlight, -1)
+}
+
+// replace connects a new client for the slot of a closed connection
+func (pool *GRPCClientPool) replace(broken *pooledConnection) {
+	client := new(GRPCClient)
+	err := pool.connect(context.Background(), client, &pool.info)
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if err != nil || pool.closed {
+		if err == nil {
+			client.Close()
+		} else {
+			log.Printf("GRPC client pool: Replacing broken connection failed: %v", err)
+		}
+		broken.replacing = false
+		return
+	}
+	for i, conn := range pool.conns {
+		if conn == broken {
+			pool.conns[i] = &pooledConnection{client: client}
+			broken.client.Close()
+			return
+		}
+	}
+	client.Close()
+}
+
+// Invoke performs a unary call on the least busy connection
+func (pool *GRPCClientPool) Invoke(ctx context.Context, method string, args, reply interface{},
+	opts ...grpc.CallOption) error {
+	conn, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	defer conn.done()
+	return conn.client.GetConnection().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream opens a stream on the least busy connection,
+// it counts as in flight until it ended or its context is done
+func (pool *GRPCClientPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string,
+	opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := pool.pick()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.client.GetConnection().NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		conn.done()
+		return nil, err
+	}
+	return (&finishingClientStream{ClientStream: stream, serverStreams: desc.ServerStreams,
+		finish: func(error) {
+			conn.done()
+		}}).watch(ctx), nil
+}
+
+// Close closes all connections of the pool
+func (pool *GRPCClientPool) Close() error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.conns == nil || pool.closed {
+		return errors.New("GRPC client pool: Is not initialized")
+	}
+	pool.closed = true
+	var firstErr error
+	for _, conn := range pool.conns {
+		if err := conn.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,196 @@
+package grpcservice_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// peerAddress returns the client address of the connection the call arrived on
+func peerAddress(ctx context.Context) *structpb.Struct {
+	p, _ := peer.FromContext(ctx)
+	response, _ := structpb.NewStruct(map[string]interface{}{"peer": p.Addr.String()})
+	return response
+}
+
+// startPeerServer starts a server telling the caller its connection, it is stopped after the test
+func startPeerServer(t *testing.T) *grpcservice.ConnectionInfo {
+	tempServer := grpcservice.NewGRPCServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+	(&grpctest.FakeService{
+		Name: "test.Peer",
+		Unary: map[string]grpctest.UnaryHandler{
+			"Address": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return peerAddress(ctx), nil
+			},
+		},
+		Streams: map[string]grpctest.StreamHandler{
+			"Hold": func(stream grpc.ServerStream) error {
+				if err := stream.SendMsg(peerAddress(stream.Context())); err != nil {
+					return err
+				}
+				for {
+					if err := stream.RecvMsg(new(structpb.Struct)); err != nil {
+						return nil
+					}
+				}
+			},
+		},
+	}).Register(tempServer.GetInstance())
+	startServer(t, tempServer)
+	t.Cleanup(func() {
+		tempServer.Stop()
+	})
+	_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+	return &grpcservice.ConnectionInfo{IP: "127.0.0.1", Port: port}
+}
+
+// callPeer returns the client address of the connection a unary call was sent on
+func callPeer(t *testing.T, conn grpc.ClientConnInterface) string {
+	response, err := grpctest.Invoke(context.Background(), conn, "test.Peer", "Address", nil)
+	test.AssertThat(t, err, nil)
+	return response.Fields["peer"].GetStringValue()
+}
+
+// holdStream opens a stream and returns the client address of its connection
+func holdStream(t *testing.T, conn grpc.ClientConnInterface) (grpc.ClientStream, string) {
+	stream, err := grpctest.NewStream(context.Background(), conn, "test.Peer", "Hold")
+	test.AssertThat(t, err, nil)
+	response := new(structpb.Struct)
+	test.AssertThat(t, stream.RecvMsg(response), nil)
+	return stream, response.Fields["peer"].GetStringValue()
+}
+
+// release ends a held stream
+func release(t *testing.T, stream grpc.ClientStream) {
+	test.AssertThat(t, stream.CloseSend(), nil)
+	test.AssertThat(t, stream.RecvMsg(new(structpb.Struct)), io.EOF)
+}
+
+func TestSuiteGRPCClientPool(t *testing.T) {
+	t.Run("ConnectingFailsOnInvalidSize", func(t *testing.T) {
+		// Exercise + Verify
+		err := grpcservice.NewGRPCClientPool(0).Connect(&grpcservice.ConnectionInfo{})
+		test.AssertThat(t, err, "GRPC client pool: Invalid size 0", "streq")
+	})
+
+	t.Run("ConnectingFailsOnInvalidInfo", func(t *testing.T) {
+		// Exercise + Verify
+		err := grpcservice.NewGRPCClientPool(2).Connect(&grpcservice.ConnectionInfo{
+			Endpoints: []string{"127.0.0.1:1"}, LoadBalancing: "random"})
+		test.AssertThat(t, err, "Unknown load balancing policy", "contains")
+	})
+
+	t.Run("StreamsAreSpreadByLeastInFlight", func(t *testing.T) {
+		// SetUp
+		info := startPeerServer(t)
+		pool := grpcservice.NewGRPCClientPool(3)
+		test.AssertThat(t, pool.Connect(info), nil)
+		defer pool.Close()
+		test.AssertThat(t, len(pool.Connections()), 3)
+
+		// Exercise
+		var streams []grpc.ClientStream
+		peers := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			stream, peer := holdStream(t, pool.GetConnection())
+			streams = append(streams, stream)
+			peers[peer] = true
+		}
+
+		// Verify
+		test.AssertThat(t, len(peers), 3)
+		for _, stream := range streams {
+			release(t, stream)
+		}
+	})
+
+	t.Run("CallsAvoidBusyConnections", func(t *testing.T) {
+		// SetUp
+		info := startPeerServer(t)
+		pool := grpcservice.NewGRPCClientPool(2)
+		test.AssertThat(t, pool.Connect(info), nil)
+		defer pool.Close()
+		stream, busy := holdStream(t, pool.GetConnection())
+
+		// Exercise + Verify
+		for i := 0; i < 5; i++ {
+			test.AssertThat(t, callPeer(t, pool.GetConnection()) != busy, true)
+		}
+		release(t, stream)
+	})
+
+	t.Run("CancelledStreamsAreNoLongerInFlight", func(t *testing.T) {
+		// SetUp
+		info := startPeerServer(t)
+		pool := grpcservice.NewGRPCClientPool(2)
+		test.AssertThat(t, pool.Connect(info), nil)
+		defer pool.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := grpctest.NewStream(ctx, pool.GetConnection(), "test.Peer", "Hold")
+		test.AssertThat(t, err, nil)
+		response := new(structpb.Struct)
+		test.AssertThat(t, stream.RecvMsg(response), nil)
+		busy := response.Fields["peer"].GetStringValue()
+		test.AssertThat(t, callPeer(t, pool.GetConnection()) != busy, true)
+
+		// Exercise
+		cancel()
+
+		// Verify
+		eventually(t, func() bool {
+			return callPeer(t, pool.GetConnection()) == busy
+		})
+	})
+
+	t.Run("BrokenConnectionsAreReplaced", func(t *testing.T) {
+		// SetUp
+		info := startPeerServer(t)
+		pool := grpcservice.NewGRPCClientPool(2)
+		test.AssertThat(t, pool.Connect(info), nil)
+		defer pool.Close()
+		broken := pool.Connections()[0]
+
+		// Exercise
+		test.AssertThat(t, broken.Close(), nil)
+
+		// Verify
+		eventually(t, func() bool {
+			callPeer(t, pool.GetConnection())
+			return pool.Connections()[0] != broken
+		})
+		stream, _ := holdStream(t, pool.GetConnection())
+		callPeer(t, pool.GetConnection())
+		release(t, stream)
+	})
+
+	t.Run("ClosingFailsIfNotInitialized", func(t *testing.T) {
+		// Exercise + Verify
+		err := grpcservice.NewGRPCClientPool(2).Close()
+		test.AssertThat(t, err, "GRPC client pool: Is not initialized", "streq")
+	})
+
+	t.Run("CallsFailAfterClose", func(t *testing.T) {
+		// SetUp
+		info := startPeerServer(t)
+		pool := grpcservice.NewGRPCClientPool(2)
+		test.AssertThat(t, pool.Connect(info), nil)
+
+		// Exercise
+		test.AssertThat(t, pool.Close(), nil)
+
+		// Verify
+		_, err := grpctest.Invoke(context.Background(), pool.GetConnection(), "test.Peer", "Address", nil)
+		test.AssertThat(t, err, "GRPC client pool: Is not connected", "streq")
+		_, err = grpctest.NewStream(context.Background(), pool.GetConnection(), "test.Peer", "Hold")
+		test.AssertThat(t, err, "GRPC client pool: Is not connected", "streq")
+		test.AssertThat(t, pool.Close(), "GRPC client pool: Is not initialized", "streq")
+	})
+}
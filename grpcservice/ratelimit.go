@@ -0,0 +1,271 @@
+package grpcservice
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterHeader is the metadata key telling rejected callers when to retry in seconds
+const retryAfterHeader = "retry-after"
+
+// maxIdlePeers is the number of peer limits kept before idle ones are dropped
+const maxIdlePeers = 1024
+
+// peerSweepInterval is the minimum time between two sweeps of idle peer limits,
+// so many new callers do not each walk all tracked peers
+const peerSweepInterval = time.Second
+
+// defaultMaxPeers is the number of callers tracked unless RateLimitConfig.MaxPeers is set
+const defaultMaxPeers = 65536
+
+// Limit combines a token bucket rate limit with a concurrency limit, zero values disable either
+type Limit struct {
+	// Rate is the number of requests per second refilling the bucket
+	Rate float64
+	// Burst is the size of the bucket, it defaults to the rate rounded up
+	Burst int
+	// MaxInFlight is the number of requests handled concurrently
+	MaxInFlight int
+}
+
+// RateLimitConfig configures the limits enforced by a RateLimiter.
+// A request has to pass all limits applying to it.
+type RateLimitConfig struct {
+	// Global limits all requests together
+	Global Limit
+	// PerMethod limits are keyed by full method name, "/package.Service/*" or "*",
+	// only the most specific matching limit applies
+	PerMethod map[string]Limit
+	// PerPeer limits every caller on its own. Callers are told apart by their
+	// Identity or Principal, so identity and auth interceptors have to run first,
+	// otherwise the peer address is used.
+	PerPeer Limit
+	// MaxPeers caps the number of callers tracked for PerPeer, 65536 by default. Once reached,
+	// requests of new callers are rejected until idle callers are dropped, which happens
+	// at most once a second.
+	MaxPeers int
+}
+
+// RateLimiter enforces rate and concurrency limits through server interceptors.
+// Requests over the limit fail with codes.ResourceExhausted and retry-after metadata.
+type RateLimiter struct {
+	config RateLimitConfig
+
+	mutex      sync.Mutex
+	global     *limitState
+	methods    map[string]*limitState
+	peers      map[string]*limitState
+	lastSweep  time.Time
+	rejections map[string]uint64
+}
+
+// limitState tracks the usage of a single Limit
+type limitState struct {
+	limit    Limit
+	tokens   float64
+	updated  time.Time
+	inFlight int
+}
+
+// NewRateLimiter creates a rate limiter enforcing the given configuration
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	now := time.Now()
+	limiter := &RateLimiter{
+		config:     config,
+		global:     newLimitState(config.Global, now),
+		methods:    make(map[string]*limitState),
+		peers:      make(map[string]*limitState),
+		rejections: make(map[string]uint64),
+	}
+	for pattern, limit := range config.PerMethod {
+		limiter.methods[pattern] = newLimitState(limit, now)
+	}
+	return limiter
+}
+
+func newLimitState(limit Limit, now time.Time) *limitState {
+	if limit.Rate > 0 && limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	return &limitState{limit: limit, tokens: float64(limit.Burst), updated: now}
+}
+
+// refill adds the tokens accumulated since the last update
+func (s *limitState) refill(now time.Time) {
+	if s.limit.Rate <= 0 {
+		return
+	}
+	s.tokens = math.Min(float64(s.limit.Burst),
+		s.tokens+now.Sub(s.updated).Seconds()*s.limit.Rate)
+	s.updated = now
+}
+
+// wait returns how long to wait until the state admits another request, 0 if it does right now
+func (s *limitState) wait() time.Duration {
+	if s.limit.MaxInFlight > 0 && s.inFlight >= s.limit.MaxInFlight {
+		return time.Second
+	}
+	if s.limit.Rate > 0 && s.tokens < 1 {
+		return time.Duration((1 - s.tokens) / s.limit.Rate * float64(time.Second))
+	}
+	return 0
+}
+
+// idle indicates that the state holds no information worth keeping
+func (s *limitState) idle() bool {
+	return s.inFlight == 0 && s.tokens >= float64(s.limit.Burst)
+}
+
+// Rejections returns the number of rejected requests per full method name
+func (r *RateLimiter) Rejections() map[string]uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rejections := make(map[string]uint64, len(r.rejections))
+	for method, count := range r.rejections {
+		rejections[method] = count
+	}
+	return rejections
+}
+
+// acquire admits the request or returns how long the caller should wait.
+// Admitted requests have to call the returned release function once handled.
+func (r *RateLimiter) acquire(ctx context.Context, fullMethod string) (func(), time.Duration) {
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	states := []*limitState{r.global}
+	if pattern, ok := mostSpecificPattern(fullMethod, func(pattern string) bool {
+		_, ok := r.methods[pattern]
+		return ok
+	}); ok {
+		states = append(states, r.methods[pattern])
+	}
+	if r.config.PerPeer != (Limit{}) {
+		state := r.peerState(peerKey(ctx), now)
+		if state == nil {
+			r.rejections[fullMethod]++
+			return nil, time.Second
+		}
+		states = append(states, state)
+	}
+
+	var wait time.Duration
+	for _, state := range states {
+		state.refill(now)
+		if stateWait := state.wait(); stateWait > wait {
+			wait = stateWait
+		}
+	}
+	if wait > 0 {
+		r.rejections[fullMethod]++
+		return nil, wait
+	}
+
+	for _, state := range states {
+		if state.limit.Rate > 0 {
+			state.tokens--
+		}
+		state.inFlight++
+	}
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for _, state := range states {
+			state.inFlight--
+		}
+	}, 0
+}
+
+// peerState returns the limit state of the caller, or nil if no more callers can be tracked
+func (r *RateLimiter) peerState(key string, now time.Time) *limitState {
+	state, ok := r.peers[key]
+	if ok {
+		return state
+	}
+	maxPeers := r.config.MaxPeers
+	if maxPeers <= 0 {
+		maxPeers = defaultMaxPeers
+	}
+	if (len(r.peers) >= maxIdlePeers || len(r.peers) >= maxPeers) &&
+		now.Sub(r.lastSweep) >= peerSweepInterval {
+		r.lastSweep = now
+		for peerKey, peerState := range r.peers {
+			peerState.refill(now)
+			if peerState.idle() {
+				delete(r.peers, peerKey)
+			}
+		}
+	}
+	if len(r.peers) >= maxPeers {
+		return nil
+	}
+	state = newLimitState(r.config.PerPeer, now)
+	r.peers[key] = state
+	return state
+}
+
+// peerKey identifies the caller by its identity, principal or address
+func peerKey(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		if identity.SPIFFEID != "" {
+			return "identity:" + identity.SPIFFEID
+		}
+		return "identity:" + identity.CommonName
+	}
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return "principal:" + principal.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "address:" + host
+		}
+		return "address:" + p.Addr.String()
+	}
+	return ""
+}
+
+// reject builds the error for a rejected request and tells the caller when to retry
+func reject(ctx context.Context, fullMethod string, wait time.Duration) error {
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	grpc.SetTrailer(ctx, metadata.Pairs(retryAfterHeader, seconds))
+	return status.Errorf(codes.ResourceExhausted,
+		"Rate limit: Too many requests for %s, retry after %ss", fullMethod, seconds)
+}
+
+// UnaryInterceptor enforces the limits on unary calls
+func (r *RateLimiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		release, wait := r.acquire(ctx, info.FullMethod)
+		if release == nil {
+			return nil, reject(ctx, info.FullMethod, wait)
+		}
+		defer release()
+		return handler(ctx, request)
+	}
+}
+
+// StreamInterceptor enforces the limits on streaming calls, a stream is in flight until it ends
+func (r *RateLimiter) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		release, wait := r.acquire(stream.Context(), info.FullMethod)
+		if release == nil {
+			return reject(stream.Context(), info.FullMethod, wait)
+		}
+		defer release()
+		return handler(srv, stream)
+	}
+}
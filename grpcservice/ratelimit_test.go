@@ -0,0 +1,192 @@
+package grpcservice_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newLimited returns a fake service whose Block method waits for the release channel to close
+func newLimited(release chan struct{}) *grpctest.FakeService {
+	return &grpctest.FakeService{
+		Name: "test.Limited",
+		Unary: map[string]grpctest.UnaryHandler{
+			"Call": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return request, nil
+			},
+			"Other": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return request, nil
+			},
+			"Block": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				<-release
+				return request, nil
+			},
+		},
+		Streams: map[string]grpctest.StreamHandler{
+			"Stream": func(stream grpc.ServerStream) error {
+				return nil
+			},
+		},
+	}
+}
+
+func TestSuiteRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RateLimitRejectsWithRetryAfter", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			Global: grpcservice.Limit{Rate: 0.5, Burst: 2}})
+		harness := grpctest.New(t, newLimited(nil).Register,
+			grpcservice.WithUnaryInterceptors(limiter.UnaryInterceptor()))
+
+		// Exercise + Verify
+		for i := 0; i < 2; i++ {
+			_, err := harness.Invoke(ctx, "test.Limited", "Call", nil)
+			test.AssertThat(t, err, nil)
+		}
+		var trailer metadata.MD
+		_, err := harness.Invoke(ctx, "test.Limited", "Call", nil, grpc.Trailer(&trailer))
+		test.AssertThat(t, status.Code(err), codes.ResourceExhausted)
+		test.AssertThat(t, trailer.Get("retry-after")[0], "2")
+		test.AssertThat(t, limiter.Rejections()["/test.Limited/Call"], uint64(1))
+	})
+
+	t.Run("MethodLimitOnlyAppliesToMatchingMethods", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			PerMethod: map[string]grpcservice.Limit{
+				"/test.Limited/Call": {Rate: 0.001, Burst: 1},
+				"/test.Limited/*":    {Rate: 1000},
+			}})
+		harness := grpctest.New(t, newLimited(nil).Register,
+			grpcservice.WithUnaryInterceptors(limiter.UnaryInterceptor()))
+
+		// Exercise + Verify
+		_, err := harness.Invoke(ctx, "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+		_, err = harness.Invoke(ctx, "test.Limited", "Call", nil)
+		test.AssertThat(t, status.Code(err), codes.ResourceExhausted)
+		_, err = harness.Invoke(ctx, "test.Limited", "Other", nil)
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("ConcurrencyLimitRejectsRequestsInExcess", func(t *testing.T) {
+		// SetUp
+		release := make(chan struct{})
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			Global: grpcservice.Limit{MaxInFlight: 1}})
+		harness := grpctest.New(t, newLimited(release).Register,
+			grpcservice.WithUnaryInterceptors(limiter.UnaryInterceptor()))
+		done := make(chan error)
+		go func() {
+			_, err := harness.Invoke(ctx, "test.Limited", "Block", nil)
+			done <- err
+		}()
+		for len(limiter.Rejections()) == 0 {
+			if _, err := harness.Invoke(ctx, "test.Limited", "Call", nil); err != nil {
+				// Exercise + Verify
+				test.AssertThat(t, status.Code(err), codes.ResourceExhausted)
+			}
+		}
+
+		// TearDown
+		close(release)
+		test.AssertThat(t, <-done, nil)
+		_, err := harness.Invoke(ctx, "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("PeerLimitIsTrackedPerPrincipal", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			PerPeer: grpcservice.Limit{Rate: 0.001, Burst: 1}})
+		verifier := grpcservice.StaticTokenVerifier{"a": "peer-a", "b": "peer-b"}
+		harness := grpctest.New(t, newLimited(nil).Register, grpcservice.WithUnaryInterceptors(
+			grpcservice.AuthUnaryInterceptor(verifier, nil), limiter.UnaryInterceptor()))
+
+		// Exercise + Verify
+		_, err := harness.Invoke(withToken("a"), "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+		_, err = harness.Invoke(withToken("a"), "test.Limited", "Call", nil)
+		test.AssertThat(t, status.Code(err), codes.ResourceExhausted)
+		_, err = harness.Invoke(withToken("b"), "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+	})
+
+	t.Run("NewPeersAreRejectedOnceMaxPeersAreTracked", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			PerPeer: grpcservice.Limit{Rate: 0.001, Burst: 1}, MaxPeers: 1})
+		verifier := grpcservice.StaticTokenVerifier{"a": "peer-a", "b": "peer-b"}
+		harness := grpctest.New(t, newLimited(nil).Register, grpcservice.WithUnaryInterceptors(
+			grpcservice.AuthUnaryInterceptor(verifier, nil), limiter.UnaryInterceptor()))
+
+		// Exercise + Verify
+		_, err := harness.Invoke(withToken("a"), "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+		_, err = harness.Invoke(withToken("b"), "test.Limited", "Call", nil)
+		test.AssertThat(t, status.Code(err), codes.ResourceExhausted)
+	})
+
+	t.Run("IdlePeersAreDroppedAtMostOncePerInterval", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			PerPeer: grpcservice.Limit{Rate: 1000000, Burst: 1}, MaxPeers: 1})
+		verifier := grpcservice.StaticTokenVerifier{"a": "peer-a", "b": "peer-b", "c": "peer-c"}
+		harness := grpctest.New(t, newLimited(nil).Register, grpcservice.WithUnaryInterceptors(
+			grpcservice.AuthUnaryInterceptor(verifier, nil), limiter.UnaryInterceptor()))
+		_, err := harness.Invoke(withToken("a"), "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+
+		// Exercise + Verify
+		_, err = harness.Invoke(withToken("b"), "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+		_, err = harness.Invoke(withToken("c"), "test.Limited", "Call", nil)
+		test.AssertThat(t, status.Code(err), codes.ResourceExhausted)
+	})
+
+	t.Run("StreamsAreLimited", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			Global: grpcservice.Limit{Rate: 0.001, Burst: 1}})
+		harness := grpctest.New(t, newLimited(nil).Register,
+			grpcservice.WithStreamInterceptors(limiter.StreamInterceptor()))
+		open := func() error {
+			stream, err := harness.NewStream(ctx, "test.Limited", "Stream")
+			if err != nil {
+				return err
+			}
+			stream.CloseSend()
+			return stream.RecvMsg(new(structpb.Struct))
+		}
+
+		// Exercise + Verify
+		test.AssertThat(t, open(), "EOF", "streq")
+		test.AssertThat(t, status.Code(open()), codes.ResourceExhausted)
+	})
+
+	t.Run("BucketRefillsOverTime", func(t *testing.T) {
+		// SetUp
+		limiter := grpcservice.NewRateLimiter(grpcservice.RateLimitConfig{
+			Global: grpcservice.Limit{Rate: 100, Burst: 1}})
+		harness := grpctest.New(t, newLimited(nil).Register,
+			grpcservice.WithUnaryInterceptors(limiter.UnaryInterceptor()))
+
+		// Exercise + Verify
+		_, err := harness.Invoke(ctx, "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+		time.Sleep(20 * time.Millisecond)
+		_, err = harness.Invoke(ctx, "test.Limited", "Call", nil)
+		test.AssertThat(t, err, nil)
+	})
+}
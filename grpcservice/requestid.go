@@ -0,0 +1,117 @@
+package grpcservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/quaponatech/golang-extensions/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDHeader is the metadata key carrying the request ID
+const requestIDHeader = "x-request-id"
+
+// requestIDField is the log field key of the request ID
+const requestIDField = "request_id"
+
+// maxRequestIDLength limits the length of request IDs accepted from callers
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of the context carrying the request ID,
+// which is forwarded on outgoing calls and added as log field
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+	return server.WithField(ctx, requestIDField, requestID)
+}
+
+// RequestIDFromContext returns the request ID of the call handled with the context
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// validRequestID accepts short, printable ASCII IDs to keep log lines intact
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// incomingRequestID returns the request ID sent by the caller or a new one
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDHeader); len(values) > 0 && validRequestID(values[0]) {
+			return values[0]
+		}
+	}
+	return newRequestID()
+}
+
+// RequestIDUnaryServerInterceptor assigns a request ID to unary calls and echoes it in the response header.
+// It is installed on every GRPCServer and GRPCWebServer.
+func RequestIDUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		requestID := incomingRequestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+		return handler(ContextWithRequestID(ctx, requestID), request)
+	}
+}
+
+// RequestIDStreamServerInterceptor is the streaming counterpart of RequestIDUnaryServerInterceptor
+func RequestIDStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		requestID := incomingRequestID(stream.Context())
+		stream.SetHeader(metadata.Pairs(requestIDHeader, requestID))
+		return handler(srv, withContext(stream, ContextWithRequestID(stream.Context(), requestID)))
+	}
+}
+
+// outgoingRequestID adds the request ID of the context to the outgoing metadata,
+// unless the caller set one explicitly
+func outgoingRequestID(ctx context.Context) context.Context {
+	requestID, ok := RequestIDFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestIDHeader)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestIDHeader, requestID)
+}
+
+// RequestIDUnaryClientInterceptor forwards the request ID of the context on unary calls.
+// It is installed on every GRPCClient connection.
+func RequestIDUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, request, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingRequestID(ctx), method, request, reply, cc, opts...)
+	}
+}
+
+// RequestIDStreamClientInterceptor forwards the request ID of the context on streaming calls
+func RequestIDStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,139 @@
+package grpcservice_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newRequestIDs returns a fake service answering with the request ID of the call.
+// Its Forward method calls the same method on the downstream connection instead.
+func newRequestIDs(logger *server.Logger, downstream func() grpc.ClientConnInterface) *grpctest.FakeService {
+	return &grpctest.FakeService{
+		Name: "test.RequestIDs",
+		Unary: map[string]grpctest.UnaryHandler{
+			"Get": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				requestID, _ := grpcservice.RequestIDFromContext(ctx)
+				logger.Infof(ctx, "Handling call")
+				return structpb.NewStruct(map[string]interface{}{"id": requestID})
+			},
+			"Forward": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return grpctest.Invoke(ctx, downstream(), "test.RequestIDs", "Get", nil)
+			},
+		},
+	}
+}
+
+func TestSuiteRequestID(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RequestIDIsGeneratedAndEchoed", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newRequestIDs(logger, nil).Register)
+		var header metadata.MD
+
+		// Exercise
+		response, err := harness.Invoke(ctx, "test.RequestIDs", "Get", nil, grpc.Header(&header))
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		requestID := response.Fields["id"].GetStringValue()
+		test.AssertThat(t, len(requestID), 32)
+		test.AssertThat(t, header.Get("x-request-id")[0], requestID)
+		test.AssertThat(t, <-logger.LogChan, "request_id="+requestID+" Handling call")
+	})
+
+	t.Run("RequestIDIsTakenFromMetadata", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newRequestIDs(logger, nil).Register)
+		var header metadata.MD
+
+		// Exercise
+		response, err := harness.Invoke(metadata.AppendToOutgoingContext(ctx, "x-request-id", "abc-123"),
+			"test.RequestIDs", "Get", nil, grpc.Header(&header))
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, response.Fields["id"].GetStringValue(), "abc-123")
+		test.AssertThat(t, header.Get("x-request-id")[0], "abc-123")
+	})
+
+	t.Run("InvalidRequestIDIsReplaced", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newRequestIDs(logger, nil).Register)
+
+		// Exercise
+		response, err := harness.Invoke(metadata.AppendToOutgoingContext(ctx,
+			"x-request-id", "forged request_id=other"), "test.RequestIDs", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, len(response.Fields["id"].GetStringValue()), 32)
+	})
+
+	t.Run("RequestIDIsForwardedByClient", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string, 2)}
+		downstream := grpctest.New(t, newRequestIDs(logger, nil).Register)
+		upstream := grpctest.New(t, newRequestIDs(logger, func() grpc.ClientConnInterface {
+			return downstream.Connection()
+		}).Register)
+
+		// Exercise
+		response, err := upstream.Invoke(metadata.AppendToOutgoingContext(ctx, "x-request-id", "hop"),
+			"test.RequestIDs", "Forward", nil)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, response.Fields["id"].GetStringValue(), "hop")
+	})
+
+	t.Run("GRPCWebServerEchoesRequestID", func(t *testing.T) {
+		// SetUp
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		webServer := grpcservice.NewGRPCWebServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+		newRequestIDs(logger, nil).Register(webServer.GetInnerInstance())
+		go webServer.Serve()
+		defer webServer.Stop()
+
+		request, _ := proto.Marshal(&structpb.Struct{})
+		frame := make([]byte, 5, 5+len(request))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+		frame = append(frame, request...)
+		req, _ := http.NewRequest(http.MethodPost,
+			"http://"+webServer.Addr().String()+"/test.RequestIDs/Get", bytes.NewReader(frame))
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		req.Header.Set("X-Request-Id", "web-42")
+
+		// Exercise
+		var response *http.Response
+		var err error
+		for i := 0; i < 100; i++ {
+			if response, err = http.DefaultClient.Do(req); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		defer response.Body.Close()
+		test.AssertThat(t, response.Header.Get("X-Request-Id"), "web-42")
+		test.AssertThat(t, <-logger.LogChan, "request_id=web-42 Handling call")
+	})
+}
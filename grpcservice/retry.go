@@ -0,0 +1,169 @@
+package grpcservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults of RetryPolicy
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryInitial     = 100 * time.Millisecond
+	defaultRetryMax         = 5 * time.Second
+	defaultRetryMultiplier  = 2
+	defaultRetryJitter      = 0.2
+)
+
+// defaultRetryableCodes are retried unless RetryPolicy.RetryableCodes is set
+var defaultRetryableCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded}
+
+// RetryPolicy retries failed unary calls of idempotent methods.
+// Streaming calls are never retried.
+type RetryPolicy struct {
+	// IdempotentMethods are full method names, "/package.Service/*" or "*" safe to call again
+	IdempotentMethods []string
+	// RetryableCodes are the status codes worth retrying, Unavailable and DeadlineExceeded by default
+	RetryableCodes []codes.Code
+	// MaxAttempts is the total number of attempts including the first one, 3 by default
+	MaxAttempts int
+	// Backoff controls the delays between attempts, 100ms doubling up to 5s with jitter by default
+	Backoff *BackoffPolicy
+	// PerAttemptTimeout limits every single attempt, so a hanging attempt fails with
+	// DeadlineExceeded and is retried. The deadline of the caller still limits all attempts.
+	PerAttemptTimeout time.Duration
+	// Budget limits retries while many calls fail, nil allows retrying every call
+	Budget *RetryBudget
+	// OnRetry is called before waiting for the next attempt, attempt counts from 1
+	OnRetry func(method string, attempt int, delay time.Duration, err error)
+}
+
+// RetryBudget throttles retries like the retryThrottling of the grpc service config:
+// every failure takes a token, every success returns TokenRatio tokens, and retries
+// are only made while more than half of MaxTokens are left.
+type RetryBudget struct {
+	maxTokens  float64
+	tokenRatio float64
+
+	mutex  sync.Mutex
+	tokens float64
+}
+
+// NewRetryBudget creates a full retry budget
+func NewRetryBudget(maxTokens, tokenRatio float64) *RetryBudget {
+	return &RetryBudget{maxTokens: maxTokens, tokenRatio: tokenRatio, tokens: maxTokens}
+}
+
+// onSuccess returns tokens to the budget
+func (b *RetryBudget) onSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += b.tokenRatio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+// onFailure takes a token and reports whether a retry is still allowed
+func (b *RetryBudget) onFailure() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+	return b.tokens > b.maxTokens/2
+}
+
+// idempotent reports whether the method may be retried
+func (p *RetryPolicy) idempotent(method string) bool {
+	for _, pattern := range p.IdempotentMethods {
+		if matchesMethod(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports whether the error is worth retrying
+func (p *RetryPolicy) retryable(err error) bool {
+	retryableCodes := p.RetryableCodes
+	if len(retryableCodes) == 0 {
+		retryableCodes = defaultRetryableCodes
+	}
+	code := status.Code(err)
+	for _, retryableCode := range retryableCodes {
+		if code == retryableCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) backoff() BackoffPolicy {
+	if p.Backoff != nil {
+		return *p.Backoff
+	}
+	return BackoffPolicy{Initial: defaultRetryInitial, Max: defaultRetryMax,
+		Multiplier: defaultRetryMultiplier, Jitter: defaultRetryJitter}
+}
+
+// invoke makes a single attempt, limited by the per attempt timeout
+func (p *RetryPolicy) invoke(ctx context.Context, method string, request, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	if p.PerAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.PerAttemptTimeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, request, reply, cc, opts...)
+}
+
+// UnaryClientInterceptor retries failed unary calls according to the policy.
+// Retries stop early once the caller's context is done or its deadline would pass while waiting.
+func (p *RetryPolicy) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, request, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !p.idempotent(method) {
+			return invoker(ctx, method, request, reply, cc, opts...)
+		}
+		maxAttempts := p.MaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = defaultRetryMaxAttempts
+		}
+		backoff := p.backoff()
+
+		for attempt := 1; ; attempt++ {
+			err := p.invoke(ctx, method, request, reply, cc, invoker, opts)
+			if err == nil {
+				if p.Budget != nil {
+					p.Budget.onSuccess()
+				}
+				return nil
+			}
+			if !p.retryable(err) || ctx.Err() != nil {
+				return err
+			}
+			if p.Budget != nil && !p.Budget.onFailure() {
+				return err
+			}
+			if attempt >= maxAttempts {
+				return err
+			}
+			delay := backoff.Delay(attempt - 1)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				return err
+			}
+			if p.OnRetry != nil {
+				p.OnRetry(method, attempt, delay, err)
+			}
+			if sleep(ctx, delay) != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package grpcservice_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newFlaky returns a fake service whose methods fail with the code until called more than failures times
+func newFlaky(calls *int32, failures int32, code codes.Code) *grpctest.FakeService {
+	handler := func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			return nil, status.Error(code, "Flaky")
+		}
+		return request, nil
+	}
+	return &grpctest.FakeService{
+		Name:  "test.Flaky",
+		Unary: map[string]grpctest.UnaryHandler{"Get": handler, "Update": handler},
+	}
+}
+
+func TestSuiteRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	fastBackoff := &grpcservice.BackoffPolicy{Initial: time.Millisecond}
+
+	connect := func(t *testing.T, calls *int32, failures int32, code codes.Code,
+		policy *grpcservice.RetryPolicy) *grpctest.Harness {
+		harness := grpctest.New(t, newFlaky(calls, failures, code).Register)
+		harness.Client = harness.Connect(&grpcservice.ConnectionInfo{Retry: policy})
+		return harness
+	}
+
+	t.Run("IdempotentMethodIsRetried", func(t *testing.T) {
+		// SetUp
+		var calls, retries int32
+		harness := connect(t, &calls, 2, codes.Unavailable, &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"/test.Flaky/Get"}, Backoff: fastBackoff,
+			OnRetry: func(method string, attempt int, delay time.Duration, err error) {
+				atomic.AddInt32(&retries, 1)
+			}})
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(3))
+		test.AssertThat(t, atomic.LoadInt32(&retries), int32(2))
+	})
+
+	t.Run("OtherMethodsAreNotRetried", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		harness := connect(t, &calls, 1, codes.Unavailable, &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"/test.Flaky/Get"}, Backoff: fastBackoff})
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Update", nil)
+
+		// Verify
+		test.AssertThat(t, status.Code(err), codes.Unavailable)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(1))
+	})
+
+	t.Run("OtherCodesAreNotRetried", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		harness := connect(t, &calls, 1, codes.Internal, &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"*"}, Backoff: fastBackoff})
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, status.Code(err), codes.Internal)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(1))
+	})
+
+	t.Run("LastErrorIsReturnedAfterMaxAttempts", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		harness := connect(t, &calls, 10, codes.Unavailable, &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"/test.Flaky/*"}, MaxAttempts: 4, Backoff: fastBackoff})
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, status.Code(err), codes.Unavailable)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(4))
+	})
+
+	t.Run("CallerDeadlineIsRespected", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		harness := connect(t, &calls, 10, codes.Unavailable, &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"*"}, MaxAttempts: 10,
+			Backoff: &grpcservice.BackoffPolicy{Initial: time.Second}})
+		deadlineCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+
+		// Exercise
+		_, err := harness.Invoke(deadlineCtx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, status.Code(err), codes.Unavailable)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(1))
+		test.AssertThat(t, time.Since(start) < 200*time.Millisecond, true)
+	})
+
+	t.Run("PerAttemptTimeoutRetriesHangingAttempts", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		hanging := &grpctest.FakeService{
+			Name: "test.Hanging",
+			Unary: map[string]grpctest.UnaryHandler{
+				"Get": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+					if atomic.AddInt32(&calls, 1) == 1 {
+						<-ctx.Done()
+						return nil, ctx.Err()
+					}
+					return request, nil
+				},
+			},
+		}
+		harness := grpctest.New(t, hanging.Register)
+		harness.Client = harness.Connect(&grpcservice.ConnectionInfo{Retry: &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"*"}, Backoff: fastBackoff,
+			PerAttemptTimeout: 50 * time.Millisecond}})
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Hanging", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(2))
+	})
+
+	t.Run("ExhaustedBudgetStopsRetries", func(t *testing.T) {
+		// SetUp
+		var calls int32
+		harness := connect(t, &calls, 10, codes.Unavailable, &grpcservice.RetryPolicy{
+			IdempotentMethods: []string{"*"}, MaxAttempts: 5, Backoff: fastBackoff,
+			Budget: grpcservice.NewRetryBudget(4, 0.1)})
+
+		// Exercise
+		_, err := harness.Invoke(ctx, "test.Flaky", "Get", nil)
+
+		// Verify
+		test.AssertThat(t, status.Code(err), codes.Unavailable)
+		test.AssertThat(t, atomic.LoadInt32(&calls), int32(2))
+	})
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package grpcservice
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnixSocket creates the socket and sets its permissions afterwards,
+// there is no umask to create it with the permissions already set
+func listenUnixSocket(path string, perms os.FileMode) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil || perms == 0 {
+		return listener, err
+	}
+	if err := os.Chmod(path, perms); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("GRPC server: Setting socket permissions failed: %v", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,63 @@
+//go:build unix
+
+package grpcservice
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// listenUnixSocket creates the socket with the permissions already set, there is no
+// moment in which it is reachable with wider permissions. The socket is created in a
+// private directory next to path, gets its permissions there and is renamed into place.
+func listenUnixSocket(path string, perms os.FileMode) (net.Listener, error) {
+	if perms == 0 {
+		return net.Listen("unix", path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, fmt.Errorf("GRPC server: Creating socket directory failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	privatePath := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, err
+	}
+	// the listener must not remove the private path, it is renamed
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(privatePath, perms); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("GRPC server: Setting socket permissions failed: %v", err)
+	}
+	if err := os.Rename(privatePath, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("GRPC server: Moving socket into place failed: %v", err)
+	}
+	return &renamedUnixListener{Listener: listener, path: path}, nil
+}
+
+// renamedUnixListener reports the final path of a renamed socket and removes it on close
+type renamedUnixListener struct {
+	net.Listener
+	path      string
+	closeOnce sync.Once
+}
+
+// Addr returns the path the socket was renamed to
+func (l *renamedUnixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket file once
+func (l *renamedUnixListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() {
+		os.Remove(l.path)
+	})
+	return err
+}
@@ -0,0 +1,323 @@
+package grpcservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// W3C trace context metadata keys
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// log field keys added to the context of traced calls
+const (
+	traceIDField = "trace_id"
+	spanIDField  = "span_id"
+)
+
+// sampledFlag is the trace flag marking a trace as recorded
+const sampledFlag = 0x01
+
+// TraceID identifies a trace across all services
+type TraceID [16]byte
+
+// String returns the trace ID as lower case hex
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the span ID as lower case hex
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span propagated to other services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	TraceFlags byte
+	// TraceState is the vendor specific tracestate header passed on unchanged
+	TraceState string
+}
+
+// IsValid reports whether trace and span ID are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// IsSampled reports whether the trace is recorded
+func (sc SpanContext) IsSampled() bool {
+	return sc.TraceFlags&sampledFlag != 0
+}
+
+// Traceparent formats the span context as W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.TraceFlags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+// Versions other than 00 are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 {
+		return sc, fmt.Errorf("Tracing: Malformed traceparent %q", traceparent)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) ||
+		parts[0] != strings.ToLower(parts[0]) {
+		return sc, fmt.Errorf("Tracing: Unsupported traceparent version %q", parts[0])
+	}
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil {
+		return sc, fmt.Errorf("Tracing: Malformed trace ID in traceparent %q", traceparent)
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil {
+		return sc, fmt.Errorf("Tracing: Malformed span ID in traceparent %q", traceparent)
+	}
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return sc, fmt.Errorf("Tracing: Malformed trace flags in traceparent %q", traceparent)
+	}
+	sc.TraceFlags = flags[0]
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("Tracing: Invalid all zero ID in traceparent %q", traceparent)
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lower case hex filling exactly the destination
+func decodeHex(value string, destination []byte) error {
+	if len(value) != 2*len(destination) || value != strings.ToLower(value) {
+		return fmt.Errorf("Tracing: Unexpected length or case of %q", value)
+	}
+	_, err := hex.Decode(destination, []byte(value))
+	return err
+}
+
+// SpanKind tells whether a span was recorded by the server or the client side of a call
+type SpanKind string
+
+// Span kinds
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindServer   SpanKind = "server"
+	SpanKindClient   SpanKind = "client"
+)
+
+// Span records a single operation within a trace
+type Span struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	// RemoteParent is set if the parent span was propagated from another service
+	RemoteParent bool
+	Start        time.Time
+	End          time.Time
+	// Code and Message describe the outcome of the call
+	Code    codes.Code
+	Message string
+
+	tracer *Tracer
+	once   sync.Once
+}
+
+// Finish ends the span with the outcome of err and hands it to the exporter if it is sampled
+func (s *Span) Finish(err error) {
+	s.once.Do(func() {
+		s.End = time.Now()
+		if err != nil {
+			st := status.Convert(err)
+			s.Code, s.Message = st.Code(), st.Message()
+		}
+		if s.tracer != nil && s.tracer.exporter != nil && s.SpanContext.IsSampled() {
+			s.tracer.exporter.ExportSpan(s)
+		}
+	})
+}
+
+// SpanExporter receives every finished and sampled span
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// InMemoryExporter keeps all exported spans in memory, e.g. for tests
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+// NewInMemoryExporter creates an empty in-memory exporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpan stores the span
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the exported spans in the order they were finished
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]*Span{}, e.spans...)
+}
+
+// Reset drops all exported spans
+func (e *InMemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// SpanFromContext returns the current span of the context, if any
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// Tracer creates spans for calls and propagates their context through W3C traceparent metadata
+type Tracer struct {
+	exporter SpanExporter
+}
+
+// NewTracer creates a tracer handing finished spans to the exporter, which may be nil
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// StartSpan starts a child span of the current span in ctx or a new trace if there is none.
+// The returned context carries the span and its IDs as log fields, the span has to be finished.
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if parent, ok := SpanFromContext(ctx); ok {
+		return t.startSpan(ctx, name, kind, parent.SpanContext, false)
+	}
+	return t.startSpan(ctx, name, kind, SpanContext{}, false)
+}
+
+func (t *Tracer) startSpan(ctx context.Context, name string, kind SpanKind,
+	parent SpanContext, remote bool) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, Start: time.Now(), tracer: t}
+	if parent.IsValid() {
+		span.SpanContext = parent
+		span.ParentSpanID = parent.SpanID
+		span.RemoteParent = remote
+	} else {
+		rand.Read(span.SpanContext.TraceID[:])
+		span.SpanContext.TraceFlags = sampledFlag
+	}
+	rand.Read(span.SpanContext.SpanID[:])
+
+	ctx = context.WithValue(ctx, spanKey{}, span)
+	ctx = server.WithField(ctx, traceIDField, span.SpanContext.TraceID.String())
+	ctx = server.WithField(ctx, spanIDField, span.SpanContext.SpanID.String())
+	return ctx, span
+}
+
+// startServerSpan continues the trace propagated in the incoming metadata
+func (t *Tracer) startServerSpan(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	var parent SpanContext
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(traceparentHeader); len(values) == 1 {
+			if sc, err := ParseTraceparent(values[0]); err == nil {
+				parent = sc
+				parent.TraceState = strings.Join(md.Get(tracestateHeader), ",")
+			}
+		}
+	}
+	return t.startSpan(ctx, strings.TrimPrefix(fullMethod, "/"), SpanKindServer, parent, true)
+}
+
+// startClientSpan starts a span for an outgoing call and injects its context into the metadata
+func (t *Tracer) startClientSpan(ctx context.Context, method string) (context.Context, *Span) {
+	ctx, span := t.StartSpan(ctx, strings.TrimPrefix(method, "/"), SpanKindClient)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(traceparentHeader, span.SpanContext.Traceparent())
+	if span.SpanContext.TraceState != "" {
+		md.Set(tracestateHeader, span.SpanContext.TraceState)
+	} else {
+		md.Delete(tracestateHeader)
+	}
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// UnaryServerInterceptor traces unary calls handled by a server
+func (t *Tracer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := t.startServerSpan(ctx, info.FullMethod)
+		response, err := handler(ctx, request)
+		span.Finish(err)
+		return response, err
+	}
+}
+
+// StreamServerInterceptor traces streaming calls handled by a server
+func (t *Tracer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		ctx, span := t.startServerSpan(stream.Context(), info.FullMethod)
+		err := handler(srv, withContext(stream, ctx))
+		span.Finish(err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor traces unary calls made by a client and propagates the trace
+func (t *Tracer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, request, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.startClientSpan(ctx, method)
+		err := invoker(ctx, method, request, reply, cc, opts...)
+		span.Finish(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor traces streaming calls made by a client and propagates the trace.
+// The span ends once the stream or its context ended.
+func (t *Tracer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := t.startClientSpan(ctx, method)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			span.Finish(err)
+			return nil, err
+		}
+		return (&finishingClientStream{ClientStream: stream,
+			serverStreams: desc.ServerStreams, finish: span.Finish}).watch(ctx), nil
+	}
+}
+
+// WithTracer traces all calls handled by the server and adds trace and span IDs
+// as log fields to the context of the handlers, see server.Logger.Infof
+func WithTracer(tracer *Tracer) ServerOption {
+	return func(o *serverOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, tracer.UnaryServerInterceptor())
+		o.streamInterceptors = append(o.streamInterceptors, tracer.StreamServerInterceptor())
+	}
+}
@@ -0,0 +1,170 @@
+package grpcservice_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/grpcservice/grpctest"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newTraced returns a fake service logging through the logger and failing on demand
+func newTraced(logger *server.Logger) *grpctest.FakeService {
+	return &grpctest.FakeService{
+		Name: "test.Traced",
+		Unary: map[string]grpctest.UnaryHandler{
+			"Call": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				logger.Infof(ctx, "Handling call")
+				return request, nil
+			},
+			"Fail": func(ctx context.Context, request *structpb.Struct) (*structpb.Struct, error) {
+				return nil, status.Error(codes.Internal, "Broken")
+			},
+		},
+	}
+}
+
+func TestSuiteTracing(t *testing.T) {
+	ctx := context.Background()
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	t.Run("TraceIsPropagatedFromClientToServer", func(t *testing.T) {
+		// SetUp
+		exporter := grpcservice.NewInMemoryExporter()
+		tracer := grpcservice.NewTracer(exporter)
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newTraced(logger).Register, grpcservice.WithTracer(tracer))
+		client := harness.Connect(&grpcservice.ConnectionInfo{Tracer: tracer})
+
+		// Exercise
+		_, err := grpctest.Invoke(metadata.AppendToOutgoingContext(ctx, "x-request-id", "traced-1"),
+			client.GetConnection(), "test.Traced", "Call", nil)
+		test.AssertThat(t, err, nil)
+
+		// Verify
+		spans := exporter.Spans()
+		test.AssertThat(t, len(spans), 2)
+		serverSpan, clientSpan := spans[0], spans[1]
+		test.AssertThat(t, serverSpan.Kind, grpcservice.SpanKindServer)
+		test.AssertThat(t, serverSpan.Name, "test.Traced/Call")
+		test.AssertThat(t, clientSpan.Kind, grpcservice.SpanKindClient)
+		test.AssertThat(t, serverSpan.SpanContext.TraceID, clientSpan.SpanContext.TraceID)
+		test.AssertThat(t, serverSpan.ParentSpanID, clientSpan.SpanContext.SpanID)
+		test.AssertThat(t, serverSpan.RemoteParent, true)
+		test.AssertThat(t, serverSpan.Code, codes.OK)
+		test.AssertThat(t, <-logger.LogChan, "request_id=traced-1 trace_id="+serverSpan.SpanContext.TraceID.String()+
+			" span_id="+serverSpan.SpanContext.SpanID.String()+" Handling call")
+	})
+
+	t.Run("CancelledStreamSpansAreExported", func(t *testing.T) {
+		// SetUp
+		exporter := grpcservice.NewInMemoryExporter()
+		harness := grpctest.New(t, newMeasured().Register)
+		client := harness.Connect(&grpcservice.ConnectionInfo{Tracer: grpcservice.NewTracer(exporter)})
+		streamCtx, cancel := context.WithCancel(ctx)
+		_, err := grpctest.NewStream(streamCtx, client.GetConnection(), "test.Measured", "Stream")
+		test.AssertThat(t, err, nil)
+
+		// Exercise
+		cancel()
+
+		// Verify
+		eventually(t, func() bool {
+			return len(exporter.Spans()) == 1
+		})
+		test.AssertThat(t, exporter.Spans()[0].Kind, grpcservice.SpanKindClient)
+		test.AssertThat(t, exporter.Spans()[0].Code, codes.Canceled)
+	})
+
+	t.Run("IncomingTraceparentIsContinued", func(t *testing.T) {
+		// SetUp
+		exporter := grpcservice.NewInMemoryExporter()
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newTraced(logger).Register,
+			grpcservice.WithTracer(grpcservice.NewTracer(exporter)))
+		outgoing := metadata.AppendToOutgoingContext(ctx,
+			"traceparent", traceparent, "tracestate", "vendor=value")
+
+		// Exercise
+		_, err := harness.Invoke(outgoing, "test.Traced", "Fail", nil)
+		test.AssertThat(t, status.Code(err), codes.Internal)
+
+		// Verify
+		spans := exporter.Spans()
+		test.AssertThat(t, len(spans), 1)
+		test.AssertThat(t, spans[0].SpanContext.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+		test.AssertThat(t, spans[0].ParentSpanID.String(), "00f067aa0ba902b7")
+		test.AssertThat(t, spans[0].SpanContext.TraceState, "vendor=value")
+		test.AssertThat(t, spans[0].Code, codes.Internal)
+		test.AssertThat(t, spans[0].Message, "Broken")
+	})
+
+	t.Run("UnsampledTraceIsNotExported", func(t *testing.T) {
+		// SetUp
+		exporter := grpcservice.NewInMemoryExporter()
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newTraced(logger).Register,
+			grpcservice.WithTracer(grpcservice.NewTracer(exporter)))
+		outgoing := metadata.AppendToOutgoingContext(ctx, "traceparent",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+		// Exercise
+		_, err := harness.Invoke(outgoing, "test.Traced", "Call", nil)
+		test.AssertThat(t, err, nil)
+
+		// Verify
+		test.AssertThat(t, len(exporter.Spans()), 0)
+		test.AssertThat(t, <-logger.LogChan, "trace_id=4bf92f3577b34da6a3ce929d0e0e4736", "contains")
+	})
+
+	t.Run("MalformedTraceparentStartsNewTrace", func(t *testing.T) {
+		// SetUp
+		exporter := grpcservice.NewInMemoryExporter()
+		logger := &server.Logger{LogChan: make(chan string, 1)}
+		harness := grpctest.New(t, newTraced(logger).Register,
+			grpcservice.WithTracer(grpcservice.NewTracer(exporter)))
+		outgoing := metadata.AppendToOutgoingContext(ctx, "traceparent", "00-garbage")
+
+		// Exercise
+		_, err := harness.Invoke(outgoing, "test.Traced", "Call", nil)
+		test.AssertThat(t, err, nil)
+
+		// Verify
+		spans := exporter.Spans()
+		test.AssertThat(t, len(spans), 1)
+		test.AssertThat(t, spans[0].SpanContext.IsValid(), true)
+		test.AssertThat(t, spans[0].RemoteParent, false)
+	})
+
+	t.Run("ParseTraceparent", func(t *testing.T) {
+		// Exercise + Verify
+		sc, err := grpcservice.ParseTraceparent(traceparent)
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, sc.Traceparent(), traceparent)
+		test.AssertThat(t, sc.IsSampled(), true)
+
+		sc, err = grpcservice.ParseTraceparent(
+			"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+		test.AssertThat(t, err, nil)
+		test.AssertThat(t, sc.SpanID.String(), "00f067aa0ba902b7")
+
+		for _, malformed := range []string{
+			"",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		} {
+			_, err = grpcservice.ParseTraceparent(malformed)
+			test.AssertThat(t, err, nil, "not")
+		}
+	})
+}
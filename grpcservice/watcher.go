@@ -0,0 +1,195 @@
+package grpcservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// subscriberBuffer is the number of events kept for a slow subscriber, older ones are dropped
+const subscriberBuffer = 16
+
+// ConnectionStateEvent describes a connectivity state change of a client connection
+type ConnectionStateEvent struct {
+	State  connectivity.State
+	Status server.Status
+	Time   time.Time
+}
+
+// StatusFromState maps connectivity states onto server states
+func StatusFromState(state connectivity.State) server.Status {
+	switch state {
+	case connectivity.Idle:
+		return server.StateInitialized
+	case connectivity.Connecting:
+		return server.StateStarting
+	case connectivity.Ready:
+		return server.StateRunning
+	case connectivity.TransientFailure:
+		return server.StateError
+	case connectivity.Shutdown:
+		return server.StateStopped
+	}
+	return server.StateUndefined
+}
+
+// ConnectionWatcher publishes the connectivity state changes of a client connection
+// to its subscribers and the logger. Failures and shutdowns are logged as warnings,
+// log messages are dropped while the logger is busy.
+type ConnectionWatcher struct {
+	conn   *grpc.ClientConn
+	logger *server.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex       sync.Mutex
+	last        ConnectionStateEvent
+	nextID      int
+	subscribers map[int]chan ConnectionStateEvent
+}
+
+// NewConnectionWatcher starts watching the connection until it shuts down or
+// the watcher is stopped. The logger may be nil.
+func NewConnectionWatcher(conn *grpc.ClientConn, logger *server.Logger) *ConnectionWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &ConnectionWatcher{conn: conn, logger: logger, cancel: cancel,
+		done: make(chan struct{}), subscribers: make(map[int]chan ConnectionStateEvent)}
+	w.last = ConnectionStateEvent{State: conn.GetState(), Time: time.Now()}
+	w.last.Status = StatusFromState(w.last.State)
+	go w.watch(ctx)
+	return w
+}
+
+// Watch starts a watcher of the client connection, see NewConnectionWatcher
+func (grpcclient GRPCClient) Watch(logger *server.Logger) (*ConnectionWatcher, error) {
+	if grpcclient.connection == nil {
+		return nil, fmt.Errorf("GRPC client: Is not initialized")
+	}
+	return NewConnectionWatcher(grpcclient.connection, logger), nil
+}
+
+func (w *ConnectionWatcher) watch(ctx context.Context) {
+	defer w.closeSubscribers()
+	state := w.State()
+	for state != connectivity.Shutdown {
+		if !w.conn.WaitForStateChange(ctx, state) {
+			return
+		}
+		state = w.conn.GetState()
+		w.publish(state)
+	}
+}
+
+func (w *ConnectionWatcher) publish(state connectivity.State) {
+	event := ConnectionStateEvent{State: state, Status: StatusFromState(state), Time: time.Now()}
+
+	w.mutex.Lock()
+	w.last = event
+	for _, subscriber := range w.subscribers {
+		send(subscriber, event)
+	}
+	w.mutex.Unlock()
+
+	message := "GRPC client: Connection to %s is %v (%v)"
+	if state == connectivity.TransientFailure || state == connectivity.Shutdown {
+		w.logger.TryWarningf(context.Background(), message, w.conn.Target(), state, event.Status)
+	} else {
+		w.logger.TryInfof(context.Background(), message, w.conn.Target(), state, event.Status)
+	}
+}
+
+// send delivers the event without blocking, dropping the oldest event of a full subscriber
+func send(subscriber chan ConnectionStateEvent, event ConnectionStateEvent) {
+	select {
+	case subscriber <- event:
+		return
+	default:
+	}
+	select {
+	case <-subscriber:
+	default:
+	}
+	select {
+	case subscriber <- event:
+	default:
+	}
+}
+
+// State returns the last known connectivity state
+func (w *ConnectionWatcher) State() connectivity.State {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.last.State
+}
+
+// Subscribe returns a channel receiving the current state followed by every change.
+// The channel is closed by the returned function, once the watcher is stopped
+// or after the connection shut down.
+func (w *ConnectionWatcher) Subscribe() (<-chan ConnectionStateEvent, func()) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	subscriber := make(chan ConnectionStateEvent, subscriberBuffer)
+	select {
+	case <-w.done:
+		close(subscriber)
+		return subscriber, func() {}
+	default:
+	}
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = subscriber
+	subscriber <- w.last
+
+	var once sync.Once
+	return subscriber, func() {
+		once.Do(func() {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			if _, ok := w.subscribers[id]; ok {
+				delete(w.subscribers, id)
+				close(subscriber)
+			}
+		})
+	}
+}
+
+// WaitUntilReady triggers connecting and waits until the connection is ready,
+// it fails once the connection shuts down or the context is done
+func (w *ConnectionWatcher) WaitUntilReady(ctx context.Context) error {
+	w.conn.Connect()
+	for {
+		state := w.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return fmt.Errorf("GRPC client: Connection to %s is shut down", w.conn.Target())
+		}
+		if !w.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("GRPC client: Connection to %s not ready: %w", w.conn.Target(), ctx.Err())
+		}
+	}
+}
+
+// Stop ends watching and closes all subscriber channels, the connection stays open
+func (w *ConnectionWatcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// closeSubscribers marks the watcher as done and closes all subscriber channels
+func (w *ConnectionWatcher) closeSubscribers() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for id, subscriber := range w.subscribers {
+		delete(w.subscribers, id)
+		close(subscriber)
+	}
+	close(w.done)
+}
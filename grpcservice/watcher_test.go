@@ -0,0 +1,155 @@
+package grpcservice_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quaponatech/golang-extensions/grpcservice"
+	"github.com/quaponatech/golang-extensions/server"
+	"github.com/quaponatech/golang-extensions/test"
+	"google.golang.org/grpc/connectivity"
+)
+
+// awaitState reads events until the expected state arrives or a second passed
+func awaitState(t *testing.T, events <-chan grpcservice.ConnectionStateEvent,
+	expected connectivity.State) grpcservice.ConnectionStateEvent {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("Events closed before state %v", expected)
+			}
+			if event.State == expected {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for state %v", expected)
+		}
+	}
+}
+
+func TestSuiteConnectionWatcher(t *testing.T) {
+	t.Run("WatchFailsIfNotInitialized", func(t *testing.T) {
+		// Exercise + Verify
+		_, err := grpcservice.GRPCClient{}.Watch(nil)
+		test.AssertThat(t, err, "GRPC client: Is not initialized", "streq")
+	})
+
+	t.Run("StatesArePublishedAndLogged", func(t *testing.T) {
+		// SetUp
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+		startServer(t, tempServer)
+		defer tempServer.Stop()
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "127.0.0.1", Port: port}), nil)
+		logger := &server.Logger{LogChan: make(chan string, 10), WarningChan: make(chan string, 10)}
+		watcher, err := tempClient.Watch(logger)
+		test.AssertThat(t, err, nil)
+		defer watcher.Stop()
+		events, unsubscribe := watcher.Subscribe()
+		defer unsubscribe()
+
+		// Exercise + Verify
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		test.AssertThat(t, watcher.WaitUntilReady(ctx), nil)
+		ready := awaitState(t, events, connectivity.Ready)
+		test.AssertThat(t, ready.Status, server.StateRunning)
+		test.AssertThat(t, watcher.State(), connectivity.Ready)
+
+		test.AssertThat(t, tempClient.Close(), nil)
+		shutdown := awaitState(t, events, connectivity.Shutdown)
+		test.AssertThat(t, shutdown.Status, server.StateStopped)
+		_, open := <-events
+		test.AssertThat(t, open, false)
+		test.AssertThat(t, <-logger.WarningChan, "is SHUTDOWN (StateStopped)", "contains")
+	})
+
+	t.Run("WaitUntilReadyHonoursContext", func(t *testing.T) {
+		// SetUp
+		silent, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer silent.Close()
+		_, port, _ := net.SplitHostPort(silent.Addr().String())
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "127.0.0.1", Port: port}), nil)
+		defer tempClient.Close()
+		watcher, _ := tempClient.Watch(nil)
+		defer watcher.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// Exercise + Verify
+		err := watcher.WaitUntilReady(ctx)
+		test.AssertThat(t, err, "not ready: context deadline exceeded", "contains")
+		test.AssertThat(t, errors.Is(err, context.DeadlineExceeded), true)
+	})
+
+	t.Run("StopClosesSubscribers", func(t *testing.T) {
+		// SetUp
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "127.0.0.1", Port: "1"}), nil)
+		defer tempClient.Close()
+		watcher, _ := tempClient.Watch(nil)
+		events, _ := watcher.Subscribe()
+
+		// Exercise
+		watcher.Stop()
+
+		// Verify
+		initial := <-events
+		test.AssertThat(t, initial.State, connectivity.Idle)
+		_, open := <-events
+		test.AssertThat(t, open, false)
+		late, _ := watcher.Subscribe()
+		_, open = <-late
+		test.AssertThat(t, open, false)
+	})
+
+	t.Run("BusyLoggerDoesNotHoldUpStop", func(t *testing.T) {
+		// SetUp
+		tempServer := grpcservice.NewGRPCServer(false, "", "", 0, grpcservice.WithHost("127.0.0.1"))
+		startServer(t, tempServer)
+		defer tempServer.Stop()
+		_, port, _ := net.SplitHostPort(tempServer.Addr().String())
+		tempClient := new(grpcservice.GRPCClient)
+		test.AssertThat(t, tempClient.Connect(&grpcservice.ConnectionInfo{
+			IP: "127.0.0.1", Port: port}), nil)
+		defer tempClient.Close()
+		logger := &server.Logger{LogChan: make(chan string), WarningChan: make(chan string)}
+		watcher, _ := tempClient.Watch(logger)
+		events, _ := watcher.Subscribe()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		test.AssertThat(t, watcher.WaitUntilReady(ctx), nil)
+		awaitState(t, events, connectivity.Ready)
+
+		// Exercise
+		stopped := make(chan struct{})
+		go func() {
+			watcher.Stop()
+			close(stopped)
+		}()
+
+		// Verify
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("Stop waited for the logger")
+		}
+	})
+
+	t.Run("StatusFromState", func(t *testing.T) {
+		// Exercise + Verify
+		test.AssertThat(t, grpcservice.StatusFromState(connectivity.Idle), server.StateInitialized)
+		test.AssertThat(t, grpcservice.StatusFromState(connectivity.Connecting), server.StateStarting)
+		test.AssertThat(t, grpcservice.StatusFromState(connectivity.TransientFailure), server.StateError)
+	})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// fieldsKey is the context key of the log fields
+type fieldsKey struct{}
+
+// Field is a key value pair prepended to every log line logged with a context carrying it
+type Field struct {
+	Key   string
+	Value string
+}
+
+// WithField returns a copy of the context carrying the log field, replacing a field with the same key
+func WithField(ctx context.Context, key, value string) context.Context {
+	parent := FieldsFromContext(ctx)
+	fields := make([]Field, 0, len(parent)+1)
+	for _, field := range parent {
+		if field.Key != key {
+			fields = append(fields, field)
+		}
+	}
+	return context.WithValue(ctx, fieldsKey{}, append(fields, Field{Key: key, Value: value}))
+}
+
+// FieldsFromContext returns the log fields carried by the context in the order they were added
+func FieldsFromContext(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]Field)
+	return fields
+}
+
+// withFields prepends the log fields of the context to the message
+func withFields(ctx context.Context, message string) string {
+	fields := FieldsFromContext(ctx)
+	if len(fields) == 0 {
+		return message
+	}
+	parts := make([]string, 0, len(fields)+1)
+	for _, field := range fields {
+		parts = append(parts, field.Key+"="+field.Value)
+	}
+	return strings.Join(append(parts, message), " ")
+}
+
+// Debugf sends a message with the log fields of the context to the debug channel
+func (l *Logger) Debugf(ctx context.Context, format string, args ...interface{}) {
+	if l != nil && l.DebugChan != nil {
+		l.DebugChan <- withFields(ctx, fmt.Sprintf(format, args...))
+	}
+}
+
+// Infof sends a message with the log fields of the context to the log channel
+func (l *Logger) Infof(ctx context.Context, format string, args ...interface{}) {
+	if l != nil && l.LogChan != nil {
+		l.LogChan <- withFields(ctx, fmt.Sprintf(format, args...))
+	}
+}
+
+// Warningf sends a message with the log fields of the context to the warning channel
+func (l *Logger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	if l != nil && l.WarningChan != nil {
+		l.WarningChan <- withFields(ctx, fmt.Sprintf(format, args...))
+	}
+}
+
+// Errorf sends an error with the log fields of the context to the error channel
+func (l *Logger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	if l != nil && l.ErrorChan != nil {
+		l.ErrorChan <- fmt.Errorf("%s", withFields(ctx, fmt.Sprintf(format, args...)))
+	}
+}
+
+// offer sends the message without waiting, it is dropped while the channel
+// is full or after the logger was stopped and its channels are closed
+func offer(channel chan string, message string) (sent bool) {
+	defer func() {
+		if recover() != nil {
+			sent = false
+		}
+	}()
+	select {
+	case channel <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryInfof is Infof for callers which must not wait for the logger, e.g. while handling requests.
+// It reports whether the message was sent.
+func (l *Logger) TryInfof(ctx context.Context, format string, args ...interface{}) bool {
+	if l == nil || l.LogChan == nil {
+		return false
+	}
+	return offer(l.LogChan, withFields(ctx, fmt.Sprintf(format, args...)))
+}
+
+// TryWarningf is Warningf for callers which must not wait for the logger, e.g. while handling requests.
+// It reports whether the message was sent.
+func (l *Logger) TryWarningf(ctx context.Context, format string, args ...interface{}) bool {
+	if l == nil || l.WarningChan == nil {
+		return false
+	}
+	return offer(l.WarningChan, withFields(ctx, fmt.Sprintf(format, args...)))
+}
+
+// offerError is offer for the error channel
+func offerError(channel chan error, err error) (sent bool) {
+	defer func() {
+		if recover() != nil {
+			sent = false
+		}
+	}()
+	select {
+	case channel <- err:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryErrorf is Errorf for callers which must not wait for the logger, e.g. background reloads.
+// It reports whether the error was sent.
+func (l *Logger) TryErrorf(ctx context.Context, format string, args ...interface{}) bool {
+	if l == nil || l.ErrorChan == nil {
+		return false
+	}
+	return offerError(l.ErrorChan, fmt.Errorf("%s", withFields(ctx, fmt.Sprintf(format, args...))))
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quaponatech/golang-extensions/test"
+)
+
+func TestSuccessWithField(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		ctx := WithField(context.Background(), "trace_id", "1")
+		ctx = WithField(ctx, "span_id", "2")
+		ctx = WithField(ctx, "trace_id", "3")
+
+		fields := FieldsFromContext(ctx)
+		test.AssertThat(t, len(fields), 2)
+		test.AssertThat(t, fields[0], Field{Key: "span_id", Value: "2"})
+		test.AssertThat(t, fields[1], Field{Key: "trace_id", Value: "3"})
+		test.AssertThat(t, len(FieldsFromContext(context.Background())), 0)
+	})
+}
+
+func TestSuccessLogWithFields(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		logChan := make(chan string, 1)
+		warningChan := make(chan string, 1)
+		errorChan := make(chan error, 1)
+		debugChan := make(chan string, 1)
+		logger := &Logger{LogChan: logChan, WarningChan: warningChan,
+			ErrorChan: errorChan, DebugChan: debugChan}
+		ctx := WithField(context.Background(), "request_id", "abc")
+
+		logger.Infof(ctx, "Handled %d", 1)
+		test.AssertThat(t, <-logChan, "request_id=abc Handled 1")
+		logger.Warningf(ctx, "Slow")
+		test.AssertThat(t, <-warningChan, "request_id=abc Slow")
+		logger.Errorf(ctx, "Failed")
+		test.AssertThat(t, (<-errorChan).Error(), "request_id=abc Failed")
+		logger.Debugf(context.Background(), "Plain")
+		test.AssertThat(t, <-debugChan, "Plain")
+
+		var nilLogger *Logger
+		nilLogger.Infof(ctx, "Dropped")
+	})
+}
+
+func TestSuccessTryLogWithoutWaiting(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		logChan := make(chan string, 1)
+		warningChan := make(chan string)
+		errorChan := make(chan error, 1)
+		logger := &Logger{LogChan: logChan, WarningChan: warningChan, ErrorChan: errorChan}
+		ctx := WithField(context.Background(), "request_id", "abc")
+
+		test.AssertThat(t, logger.TryInfof(ctx, "Handled %d", 1), true)
+		test.AssertThat(t, logger.TryInfof(ctx, "Full"), false)
+		test.AssertThat(t, <-logChan, "request_id=abc Handled 1")
+		test.AssertThat(t, logger.TryWarningf(ctx, "Nobody listens"), false)
+		test.AssertThat(t, logger.TryErrorf(ctx, "Failed"), true)
+		test.AssertThat(t, (<-errorChan).Error(), "request_id=abc Failed")
+
+		close(logChan)
+		test.AssertThat(t, logger.TryInfof(ctx, "Stopped"), false)
+		close(errorChan)
+		test.AssertThat(t, logger.TryErrorf(ctx, "Stopped"), false)
+		var nilLogger *Logger
+		test.AssertThat(t, nilLogger.TryWarningf(ctx, "Dropped"), false)
+	})
+}
@@ -43,6 +43,9 @@ type Logger struct {
 	LogChan     chan string
 	DebugChan   chan string
 	DebugLevel  int
+
+	observerMutex   sync.Mutex
+	statusObservers []func(Status)
 }
 
 // New should not be used but returns a minimal valid instance of a ServerLogger
@@ -135,6 +138,28 @@ func (l *Logger) StartLogger() error {
 	return nil
 }
 
+// ServerName returns the name of the server the logger logs for
+func (l *Logger) ServerName() string {
+	return l.serverName
+}
+
+// OnStatus registers an observer called with every status received on the status channel,
+// regardless of the debug level. Observers are called from the status channel listener.
+func (l *Logger) OnStatus(observer func(Status)) {
+	l.observerMutex.Lock()
+	defer l.observerMutex.Unlock()
+	l.statusObservers = append(l.statusObservers, observer)
+}
+
+func (l *Logger) notifyStatusObservers(status Status) {
+	l.observerMutex.Lock()
+	observers := l.statusObservers
+	l.observerMutex.Unlock()
+	for _, observer := range observers {
+		observer(status)
+	}
+}
+
 // StopLogger stops the channels and closes the log files
 func (l *Logger) StopLogger() {
 	log.Println(l.prefix + logPrefix + "Stopping Server Logger")
@@ -181,6 +206,7 @@ func (l *Logger) listenToStatusChannel() {
 		if !ok {
 			break
 		}
+		l.notifyStatusObservers(msg)
 		if l.DebugLevel > State {
 			continue
 		}
@@ -68,3 +68,21 @@ func TestFailureNewLogger(t *testing.T) {
 		test.AssertThat(t, logger, nil, "not")
 	})
 }
+
+func TestSuccessOnStatus(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		logger := NewLogger("serverName", "", "", nil, nil, nil, nil, nil, Quiet)
+		observed := make(chan Status, 2)
+		logger.OnStatus(func(status Status) {
+			observed <- status
+		})
+		test.AssertThat(t, logger.StartLogger(), nil)
+
+		logger.StatusChan <- StateRunning
+		logger.StatusChan <- StateStopped
+		test.AssertThat(t, <-observed, StateRunning)
+		test.AssertThat(t, <-observed, StateStopped)
+
+		logger.StopLogger()
+	})
+}